@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/standoffvenus/future/internal/application"
+	"github.com/standoffvenus/future/internal/appointment"
+	"github.com/standoffvenus/future/internal/configuration"
+	"github.com/standoffvenus/future/internal/tenant"
+)
+
+const Insert = `
+INSERT OR IGNORE INTO %s(id, tenant_id, trainer_id, user_id, starts_at, ends_at)
+     VALUES (:id, :tenant_id, :trainer_id, :user_id, :start, :end)
+`
+
+var (
+	DatabaseFile = flag.String("db", "bench.sqlite3", "Sets the SQLite3 database file to seed and plan queries against")
+	Rows         = flag.Int("rows", 1_000_000, "Sets the number of appointments seeded before planning")
+	Trainers     = flag.Int("trainers", 1_000, "Sets the number of trainers the seeded appointments are spread across")
+)
+
+// Seeded appointments are laid out back to back for every trainer, starting here.
+var epoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func main() {
+	flag.Parse()
+
+	application.RunWithExit(func(ctx context.Context) error {
+		if *Rows <= 0 || *Trainers <= 0 {
+			return fmt.Errorf("rows and trainers must be positive")
+		}
+
+		db, err := application.OpenSQLite3DB(ctx, *DatabaseFile, configuration.BusyTimeout)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		repository := appointment.SQLRepository{
+			Table:    configuration.Table,
+			Database: db,
+		}
+		if err := repository.CreateSchema(ctx); err != nil {
+			return err
+		}
+
+		if err := seed(ctx, db); err != nil {
+			return err
+		}
+
+		// A day of trainer 0's appointments, partway into the seeded ones;
+		// both statements should only search that stretch of the
+		// (tenant_id, trainer_id, starts_at) index.
+		ctx = tenant.WithID(ctx, tenant.Default)
+		start := epoch.Add(24 * time.Hour)
+		times := appointment.Range{Start: start, End: start.Add(24 * time.Hour)}
+
+		query, args := repository.TrainerRangeQuery(ctx, "0", times, appointment.Filter{}, appointment.Page{Limit: appointment.DefaultPageLimit})
+		if err := printPlan(ctx, db, "Range query plan", query, args); err != nil {
+			return err
+		}
+
+		query, args = repository.ConflictQuery(ctx, appointment.Appointment{
+			ID:        "plan",
+			TrainerID: "0",
+			Start:     times.Start,
+			End:       times.Start.Add(configuration.LengthOfAppointment),
+		})
+
+		return printPlan(ctx, db, "Conflict check plan", query, args)
+	})
+}
+
+func seed(ctx context.Context, db *sql.DB) error {
+	var count int
+	row := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", configuration.Table))
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+
+	if count >= *Rows {
+		return nil
+	}
+
+	log.
+		Info().
+		Fields(map[string]any{
+			"existing": count,
+			"rows":     *Rows,
+		}).
+		Msg("Seeding benchmark database.")
+
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	stmt, err := txn.PrepareContext(ctx, fmt.Sprintf(Insert, configuration.Table))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i := 0; i < *Rows; i++ {
+		trainer, slot := i%*Trainers, i / *Trainers
+		start := slotTime(slot)
+
+		_, err := stmt.ExecContext(ctx,
+			sql.Named("id", fmt.Sprintf("seed-%d", i)),
+			sql.Named("tenant_id", tenant.Default),
+			sql.Named("trainer_id", strconv.Itoa(trainer)),
+			sql.Named("user_id", strconv.Itoa(i%(*Trainers*10))),
+			sql.Named("start", start.Unix()),
+			sql.Named("end", start.Add(configuration.LengthOfAppointment).Unix()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+func printPlan(ctx context.Context, db *sql.DB, name, query string, args []any) error {
+	rows, err := db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	fmt.Printf("%s:\n", name)
+	for rows.Next() {
+		var (
+			id, parent, unused int
+			detail             string
+		)
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return err
+		}
+
+		fmt.Printf("  %s\n", detail)
+	}
+	fmt.Println()
+
+	return rows.Err()
+}
+
+func slotTime(slot int) time.Time {
+	return epoch.Add(time.Duration(slot) * configuration.LengthOfAppointment)
+}
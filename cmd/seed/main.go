@@ -12,19 +12,11 @@ import (
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/standoffvenus/future/internal/application"
+	"github.com/standoffvenus/future/internal/appointment"
 	"github.com/standoffvenus/future/internal/configuration"
+	"github.com/standoffvenus/future/internal/tenant"
 )
 
-const Create = `
-CREATE TABLE IF NOT EXISTS %s(
-    id         TEXT PRIMARY KEY,
-    trainer_id TEXT NOT NULL,
-    user_id    TEXT NOT NULL,
-    starts_at  INTEGER NOT NULL,
-    ends_at    INTEGER NOT NULL
-)
-`
-
 const Insert = `
 INSERT OR REPLACE INTO %s(
 	id,
@@ -41,6 +33,24 @@ INSERT OR REPLACE INTO %s(
 )
 `
 
+// Every trainer and member in the file is added to the directory, so their
+// appointments can be rescheduled and more booked for them.
+const InsertEntry = `
+INSERT OR IGNORE INTO %s(
+	tenant_id,
+	id,
+	display_name,
+	time_zone,
+	active
+) VALUES (
+	:tenant_id,
+	:id,
+	:display_name,
+	:time_zone,
+	1
+)
+`
+
 var (
 	JSONFile     = flag.String("json", "appointments.json", "Sets the path to the seeding JSON file")
 	DatabaseFile = flag.String("db", "db.sqlite3", "Sets the SQLite3 database file to use")
@@ -50,21 +60,25 @@ func main() {
 	flag.Parse()
 
 	application.RunWithExit(func(ctx context.Context) error {
-		db, err := application.OpenSQLite3DB(ctx, *DatabaseFile)
+		db, err := application.OpenSQLite3DB(ctx, *DatabaseFile, configuration.BusyTimeout)
 		if err != nil {
 			return err
 		}
 		defer db.Close()
 
-		txn, err := db.BeginTx(ctx, nil)
-		if err != nil {
+		repository := appointment.SQLRepository{
+			Table:    configuration.Table,
+			Database: db,
+		}
+		if err := repository.CreateSchema(ctx); err != nil {
 			return err
 		}
-		defer txn.Rollback()
 
-		if _, err := txn.ExecContext(ctx, fmt.Sprintf(Create, configuration.Table)); err != nil {
+		txn, err := db.BeginTx(ctx, nil)
+		if err != nil {
 			return err
 		}
+		defer txn.Rollback()
 
 		fileBytes, err := fs.ReadFile(os.DirFS("."), *JSONFile)
 		if err != nil {
@@ -76,8 +90,15 @@ func main() {
 			return err
 		}
 
-		formattedInsert := fmt.Sprintf(Insert, configuration.Table)
+		database := configuration.Default().Database
+		formattedInsert := fmt.Sprintf(Insert, database.Table)
+		formattedInsertTrainer := fmt.Sprintf(InsertEntry, database.TrainersTable())
+		formattedInsertMember := fmt.Sprintf(InsertEntry, database.MembersTable())
 		for _, apt := range appointments {
+			if length := apt.End.Sub(apt.Start); length <= 0 || length > appointment.MaxLengthOfAppointment {
+				return fmt.Errorf("appointment %s must end after it starts and last at most %s, not %s", toString(apt.ID), appointment.MaxLengthOfAppointment, length)
+			}
+
 			_, err := txn.ExecContext(ctx, formattedInsert,
 				sql.Named("id", toString(apt.ID)),
 				sql.Named("trainer_id", toString(apt.TrainerID)),
@@ -87,6 +108,24 @@ func main() {
 			if err != nil {
 				return err
 			}
+
+			_, err = txn.ExecContext(ctx, formattedInsertTrainer,
+				sql.Named("tenant_id", tenant.Default),
+				sql.Named("id", toString(apt.TrainerID)),
+				sql.Named("display_name", "Trainer "+toString(apt.TrainerID)),
+				sql.Named("time_zone", configuration.LocationPST.String()))
+			if err != nil {
+				return err
+			}
+
+			_, err = txn.ExecContext(ctx, formattedInsertMember,
+				sql.Named("tenant_id", tenant.Default),
+				sql.Named("id", toString(apt.UserID)),
+				sql.Named("display_name", "Member "+toString(apt.UserID)),
+				sql.Named("time_zone", configuration.LocationPST.String()))
+			if err != nil {
+				return err
+			}
 		}
 
 		if err := txn.Commit(); err != nil {
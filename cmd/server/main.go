@@ -2,65 +2,421 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
 	"net/http"
-	"strings"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/rs/zerolog/log"
 	"github.com/standoffvenus/future/internal/application"
 	"github.com/standoffvenus/future/internal/appointment"
+	"github.com/standoffvenus/future/internal/auth"
+	"github.com/standoffvenus/future/internal/build"
 	"github.com/standoffvenus/future/internal/configuration"
+	"github.com/standoffvenus/future/internal/directory"
+	"github.com/standoffvenus/future/internal/empty"
 	"github.com/standoffvenus/future/internal/handler"
+	"github.com/standoffvenus/future/internal/idempotency"
+	"github.com/standoffvenus/future/internal/outbox"
+	"github.com/standoffvenus/future/internal/tracing"
 )
 
 var (
-	File = flag.String("file", "db.sqlite3", "Sets the file where the SQLite database is stored")
-	Port = flag.Int("port", 8080, "Sets the port the server will run on")
+	ConfigFile         = flag.String("config", "", "Sets the YAML configuration file; APPT_* environment variables and flags override it")
+	File               = flag.String("file", configuration.DatabaseFile, "Sets the file where the SQLite database is stored")
+	Port               = flag.Int("port", configuration.Port, "Sets the port the server will run on")
+	DrainDelay         = flag.Duration("drain-delay", configuration.DrainDelay, "Sets how long the server keeps serving after a shutdown signal, while failing readiness, before it drains")
+	DrainTimeout       = flag.Duration("drain-timeout", configuration.DrainTimeout, "Sets how long in-flight requests may finish after a shutdown signal")
+	MaxBodyBytes       = flag.Int64("max-body-bytes", configuration.MaxBodyBytes, "Sets the largest request body, in bytes, the server will accept")
+	APIKeysFile        = flag.String("api-keys", "", "Sets the JSON file of API keys accepted via the X-API-Key header")
+	HS256SecretFile    = flag.String("jwt-hs256-secret", "", "Sets the file holding the secret for HS256 signed bearer tokens")
+	RS256PublicKeyFile = flag.String("jwt-rs256-public-key", "", "Sets the PEM file holding the public key for RS256 signed bearer tokens")
+	TraceExporter      = flag.String("trace-exporter", tracing.ExporterNone, "Sets where traces are exported: none, stdout or otlp")
+	OTLPEndpoint       = flag.String("otlp-endpoint", "", "Sets the host:port of the OTLP/HTTP collector traces are exported to")
+	OTLPInsecure       = flag.Bool("otlp-insecure", false, "Exports traces to the OTLP collector over plain HTTP")
+	TraceSampleRatio   = flag.Float64("trace-sample-ratio", 1, "Sets the fraction of new traces that are recorded")
+)
+
+const (
+	// How long buffered spans may take to export on shutdown.
+	traceFlushTimeout = 5 * time.Second
+
+	// How often the configuration file is checked for changes.
+	configPollInterval = 5 * time.Second
 )
 
 func main() {
 	flag.Parse()
 
 	application.RunWithExit(func(ctx context.Context) error {
-		if strings.TrimSpace(*File) == "" {
-			return errors.New("no database file specified")
+		// Caught from the start, since SIGHUP would otherwise kill the server
+		// while it's still starting up. A reload asked for then is applied
+		// once the reloader runs.
+		reloads := make(chan os.Signal, 1)
+		signal.Notify(reloads, syscall.SIGHUP)
+		defer signal.Stop(reloads)
+
+		config, err := loadConfig()
+		if err != nil {
+			return err
 		}
 
-		db, err := application.OpenSQLite3DB(ctx, *File)
+		shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+			ServiceName:    "appointment-service",
+			ServiceVersion: build.Version,
+			Exporter:       *TraceExporter,
+			OTLPEndpoint:   *OTLPEndpoint,
+			OTLPInsecure:   *OTLPInsecure,
+			SampleRatio:    *TraceSampleRatio,
+			Stdout:         os.Stdout,
+		})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			flushCtx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+			defer cancel()
+
+			if err := shutdownTracing(flushCtx); err != nil {
+				log.
+					Error().
+					Err(err).
+					Msg("Could not flush traces.")
+			}
+		}()
+
+		db, err := application.OpenSQLite3DB(ctx, config.Database.File, config.Database.BusyTimeout)
 		if err != nil {
 			return err
 		}
 
+		// Deferred before serving, so it only runs once requests have drained.
+		defer func() {
+			if err := db.Close(); err != nil {
+				log.
+					Error().
+					Err(err).
+					Msg("Could not close database.")
+			}
+		}()
+
 		repository := appointment.SQLRepository{
-			Table:    configuration.Table,
+			Table:    config.Database.Table,
+			Database: db,
+		}
+		if err := repository.CreateSchema(ctx); err != nil {
+			return err
+		}
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collectors.NewDBStatsCollector(db, repository.Table))
+
+		trainers := directory.SQLRepository{
+			Table:    config.Database.TrainersTable(),
+			Database: db,
+		}
+		members := directory.SQLRepository{
+			Table:    config.Database.MembersTable(),
 			Database: db,
 		}
+		resources := directory.SQLRepository{
+			Table:    config.Database.ResourcesTable(),
+			Database: db,
+		}
+
+		rules := appointment.NewRuleSet(config.Rules())
 		service := appointment.Service{
-			Repository:          &repository,
-			LengthOfAppointment: configuration.LengthOfAppointment,
-			BusinessHours:       configuration.BusinessHours,
+			Repository: &repository,
+			Rules:      rules,
+			Trainers:   &trainers,
+			Members:    &members,
+			Resources:  &resources,
+			Metrics:    appointment.NewMetrics(registry),
 		}
 
-		router := handler.NewRouter([]handler.Endpoint{
+		reloader := configuration.Reloader{
+			File:     *ConfigFile,
+			Interval: configPollInterval,
+			Override: overrideFromFlags,
+			Apply: func(config configuration.Config) {
+				rules.Store(config.Rules())
+			},
+		}
+		go reloader.Run(ctx, config, reloads)
+
+		// Waited for before the database closes, so no delivery is cut off
+		// mid-query.
+		dispatched := make(chan struct{})
+		defer func() { <-dispatched }()
+
+		if sinks := outboxSinks(config.Outbox); len(sinks) > 0 {
+			dispatcher := outbox.Dispatcher{
+				Store:       &outbox.SQLStore{Table: config.Database.OutboxTable(), Database: db},
+				Sinks:       sinks,
+				Interval:    config.Outbox.PollInterval,
+				BatchSize:   config.Outbox.BatchSize,
+				MaxBackoff:  config.Outbox.MaxBackoff,
+				MaxAttempts: config.Outbox.MaxAttempts,
+				Retention:   config.Outbox.Retention,
+				Metrics:     outbox.NewMetrics(registry),
+			}
+			go func() {
+				defer close(dispatched)
+				dispatcher.Run(ctx)
+			}()
+		} else {
+			close(dispatched)
+			log.Info().Msg("No outbox sinks configured; appointment events are kept until one is.")
+		}
+
+		idempotencyStore := idempotency.SQLStore{
+			Table:    config.Database.IdempotencyTable(),
+			Database: db,
+		}
+
+		authenticator, err := loadAuthenticator()
+		if err != nil {
+			return err
+		}
+
+		// The tenant is known before authenticating, so credentials can be
+		// checked against it.
+		protected := []handler.Middleware{
+			handler.Tenant(config.Tenancy.Domain, func(id string) bool {
+				_, ok := rules.Load(id)
+				return ok
+			}),
+		}
+		if authenticator.Enabled() {
+			protected = append(protected, handler.Authenticate(&authenticator))
+		} else {
+			log.Warn().Msg("No API keys or token keys configured; appointment endpoints accept anonymous requests.")
+		}
+
+		// Keys are scoped to the caller, so they're checked after authentication.
+		creating := append(append([]handler.Middleware{}, protected...),
+			handler.Idempotency(&idempotencyStore, config.Idempotency.KeyTTL, config.Idempotency.Lease))
+
+		// An open database keeps working after its file is deleted, until the
+		// server restarts, so the file is checked as well.
+		checks := []handler.Check{
+			{Name: "database_file", Check: func(context.Context) error {
+				_, err := os.Stat(config.Database.File)
+				return err
+			}},
+			{Name: "database", Check: db.PingContext},
+			{Name: "schema", Check: repository.CheckSchema},
+		}
+
+		var readiness handler.Readiness
+		endpoints := []handler.Endpoint{
 			{
 				Path:    "/",
 				Method:  http.MethodGet,
-				Handler: handler.Health(),
+				Handler: handler.Health(&readiness),
+			},
+			{
+				Path:    "/healthz",
+				Method:  http.MethodGet,
+				Handler: handler.Live(),
 			},
 			{
-				Path:    "/appointment",
-				Method:  http.MethodPut,
-				Handler: handler.CreateAppointment(&service),
+				Path:    "/readyz",
+				Method:  http.MethodGet,
+				Handler: handler.Ready(&readiness, config.Server.HealthCheckTimeout, checks...),
 			},
 			{
-				Path:    fmt.Sprintf("/appointment/trainer/:%s", handler.PathParameterTrainerID),
+				Path:    "/metrics",
 				Method:  http.MethodGet,
-				Handler: handler.FindAppointmentsForTrainer(&service),
+				Handler: handler.Metrics(registry),
+			},
+			{
+				Path:       "/appointment",
+				Method:     http.MethodPost,
+				Handler:    handler.CreateAppointment(&service),
+				Middleware: creating,
+			},
+			{
+				Path:       fmt.Sprintf("/appointment/:%s", handler.PathParameterID),
+				Method:     http.MethodPut,
+				Handler:    handler.CreateAppointmentWithID(&service),
+				Middleware: creating,
+			},
+			{
+				Path:       fmt.Sprintf("/appointment/:%s", handler.PathParameterID),
+				Method:     http.MethodGet,
+				Handler:    handler.GetAppointment(&service),
+				Middleware: protected,
+			},
+			{
+				Path:       fmt.Sprintf("/appointment/:%s", handler.PathParameterID),
+				Method:     http.MethodPatch,
+				Handler:    handler.UpdateAppointment(&service),
+				Middleware: protected,
+			},
+			{
+				Path:       fmt.Sprintf("/appointment/:%s/cancel", handler.PathParameterID),
+				Method:     http.MethodPost,
+				Handler:    handler.CancelAppointment(&service),
+				Middleware: protected,
+			},
+			{
+				Path:       fmt.Sprintf("/appointment/:%s/history", handler.PathParameterID),
+				Method:     http.MethodGet,
+				Handler:    handler.GetAppointmentHistory(&service),
+				Middleware: protected,
+			},
+			{
+				Path:       fmt.Sprintf("%s/:%s/appointments", handler.Trainers.Path, handler.PathParameterID),
+				Method:     http.MethodGet,
+				Handler:    handler.FindAppointmentsForTrainer(&service),
+				Middleware: protected,
+			},
+			{
+				Path:       fmt.Sprintf("%s/:%s/appointments", handler.Resources.Path, handler.PathParameterID),
+				Method:     http.MethodGet,
+				Handler:    handler.FindAppointmentsForResource(&service),
+				Middleware: protected,
 			},
+		}
+		endpoints = append(endpoints, directoryEndpoints(handler.Trainers, &trainers, protected)...)
+		endpoints = append(endpoints, directoryEndpoints(handler.Members, &members, protected)...)
+		endpoints = append(endpoints, directoryEndpoints(handler.Resources, &resources, protected)...)
+
+		router := handler.NewRouter(endpoints, handler.NewRequestMetrics(registry), handler.RequestID(), handler.Tracing(), handler.Logging(), handler.Recovery(), handler.MaxBodySize(config.Server.MaxBodyBytes))
+
+		return router.Serve(ctx, fmt.Sprintf(":%d", config.Server.Port), handler.Drain{
+			Delay:     config.Server.DrainDelay,
+			Timeout:   config.Server.DrainTimeout,
+			Readiness: &readiness,
 		})
+	})
+}
+
+// loadConfig reads the configuration file and environment, then applies any
+// flags given on the command line, which take precedence.
+func loadConfig() (configuration.Config, error) {
+	config, err := configuration.Load(*ConfigFile)
+	if err != nil {
+		return configuration.Config{}, err
+	}
+	overrideFromFlags(&config)
 
-		return router.Serve(ctx, fmt.Sprintf(":%d", *Port))
+	return config, config.Validate()
+}
+
+func overrideFromFlags(config *configuration.Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "file":
+			config.Database.File = *File
+		case "port":
+			config.Server.Port = *Port
+		case "drain-delay":
+			config.Server.DrainDelay = *DrainDelay
+		case "drain-timeout":
+			config.Server.DrainTimeout = *DrainTimeout
+		case "max-body-bytes":
+			config.Server.MaxBodyBytes = *MaxBodyBytes
+		}
 	})
 }
+
+func directoryEndpoints(kind handler.DirectoryKind, dir handler.Directory, middleware []handler.Middleware) []handler.Endpoint {
+	entryPath := fmt.Sprintf("%s/:%s", kind.Path, handler.PathParameterID)
+
+	return []handler.Endpoint{
+		{
+			Path:       kind.Path,
+			Method:     http.MethodPost,
+			Handler:    handler.CreateDirectoryEntry(kind, dir),
+			Middleware: middleware,
+		},
+		{
+			Path:       kind.Path,
+			Method:     http.MethodGet,
+			Handler:    handler.ListDirectoryEntries(kind, dir),
+			Middleware: middleware,
+		},
+		{
+			Path:       entryPath,
+			Method:     http.MethodGet,
+			Handler:    handler.GetDirectoryEntry(kind, dir),
+			Middleware: middleware,
+		},
+		{
+			Path:       entryPath,
+			Method:     http.MethodPatch,
+			Handler:    handler.UpdateDirectoryEntry(kind, dir),
+			Middleware: middleware,
+		},
+		{
+			Path:       entryPath,
+			Method:     http.MethodDelete,
+			Handler:    handler.DeleteDirectoryEntry(kind, dir),
+			Middleware: middleware,
+		},
+	}
+}
+
+func outboxSinks(config configuration.Outbox) []outbox.Sink {
+	var sinks []outbox.Sink
+	if config.Log {
+		sinks = append(sinks, outbox.LogSink{})
+	}
+
+	if !empty.String(config.File) {
+		sinks = append(sinks, &outbox.FileSink{File: config.File})
+	}
+
+	if !empty.String(config.WebhookURL) {
+		sinks = append(sinks, &outbox.WebhookSink{
+			URL:    config.WebhookURL,
+			Client: &http.Client{Timeout: config.WebhookTimeout},
+		})
+	}
+
+	return sinks
+}
+
+func loadAuthenticator() (auth.Authenticator, error) {
+	var authenticator auth.Authenticator
+	if !empty.String(*APIKeysFile) {
+		keys, err := auth.LoadAPIKeys(*APIKeysFile)
+		if err != nil {
+			return auth.Authenticator{}, err
+		}
+
+		authenticator.APIKeys = keys
+	}
+
+	if !empty.String(*HS256SecretFile) || !empty.String(*RS256PublicKeyFile) {
+		authenticator.Tokens = new(auth.TokenVerifier)
+	}
+
+	if !empty.String(*HS256SecretFile) {
+		secret, err := auth.LoadHS256Secret(*HS256SecretFile)
+		if err != nil {
+			return auth.Authenticator{}, err
+		}
+
+		authenticator.Tokens.HS256Secret = secret
+	}
+
+	if !empty.String(*RS256PublicKeyFile) {
+		key, err := auth.LoadRS256PublicKey(*RS256PublicKeyFile)
+		if err != nil {
+			return auth.Authenticator{}, err
+		}
+
+		authenticator.Tokens.RS256PublicKey = key
+	}
+
+	return authenticator, nil
+}
@@ -3,11 +3,14 @@ package application
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog/log"
 )
 
@@ -25,10 +28,17 @@ func RunWithExit(fn func(context.Context) error) {
 	}
 }
 
+// Run cancels fn's context on an interrupt, or the SIGTERM sent by container
+// runtimes; a second signal kills the process.
 func Run(fn func(context.Context) error) error {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+
 	if err := fn(ctx); err != nil {
 		return err
 	}
@@ -36,8 +46,16 @@ func Run(fn func(context.Context) error) error {
 	return nil
 }
 
-func OpenSQLite3DB(ctx context.Context, file string) (*sql.DB, error) {
-	connectionString := fmt.Sprintf("file:%s?cache=shared", file)
+// OpenSQLite3DB opens the database in file. busyTimeout is how long a
+// connection waits on a locked database before giving up with SQLITE_BUSY.
+func OpenSQLite3DB(ctx context.Context, file string, busyTimeout time.Duration) (*sql.DB, error) {
+	// WAL lets readers proceed alongside the single writer, and immediate
+	// transactions take the write lock up front so a read-then-write
+	// transaction can't be interleaved with another writer's.
+	connectionString := fmt.Sprintf(
+		"file:%s?_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate",
+		file,
+		busyTimeout.Milliseconds())
 	db, err := sql.Open("sqlite3", connectionString)
 	if err != nil {
 		return nil, err
@@ -50,3 +68,17 @@ func OpenSQLite3DB(ctx context.Context, file string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// IsSQLiteError reports whether err is a SQLite error with any of codes.
+func IsSQLiteError(err error, codes ...sqlite3.ErrNoExtended) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		for _, c := range codes {
+			if sqliteErr.ExtendedCode == c {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -2,10 +2,40 @@ package appointment
 
 import "time"
 
+type Status string
+
+const (
+	StatusScheduled Status = "scheduled"
+	StatusCancelled Status = "cancelled"
+)
+
 type Appointment struct {
 	ID        string
 	TrainerID string
 	UserID    string
 	Start     time.Time
 	End       time.Time
+	Status    Status
+
+	// ResourceIDs are the rooms and equipment the appointment claims, which
+	// can't be claimed by another scheduled appointment at the same time.
+	ResourceIDs []string
+
+	// Notes are free text, such as the session's goals, and Metadata tags
+	// the booking for the front desk, such as "package": "PT10".
+	Notes    string
+	Metadata map[string]string
+
+	// Version starts at 1 and is incremented by the repository on every
+	// update.
+	Version int64
+}
+
+func (s Status) Valid() bool {
+	switch s {
+	case StatusScheduled, StatusCancelled:
+		return true
+	}
+
+	return false
 }
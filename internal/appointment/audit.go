@@ -0,0 +1,190 @@
+package appointment
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/standoffvenus/future/internal/audit"
+	"github.com/standoffvenus/future/internal/tenant"
+	"github.com/standoffvenus/future/internal/tracing"
+)
+
+type AuditAction string
+
+const (
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditCancel AuditAction = "cancel"
+)
+
+// AuditEntry records one change to an appointment. Before is nil for the
+// change that created it.
+type AuditEntry struct {
+	AppointmentID string
+	Action        AuditAction
+	Source        audit.Source
+	At            time.Time
+	Before        *Appointment
+	After         *Appointment
+}
+
+// Appointments are stored in the audit log and outbox as JSON, so entries
+// keep whatever the appointment looked like even as its table changes.
+type snapshot struct {
+	ID          string            `json:"id"`
+	TrainerID   string            `json:"trainer_id"`
+	UserID      string            `json:"user_id"`
+	Start       time.Time         `json:"starts_at"`
+	End         time.Time         `json:"ends_at"`
+	Status      Status            `json:"status"`
+	Version     int64             `json:"version"`
+	ResourceIDs []string          `json:"resource_ids,omitempty"`
+	Notes       string            `json:"notes,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// AuditTable holds every appointment's changes, oldest first.
+func (r *SQLRepository) AuditTable() string {
+	return r.Table + "_audit"
+}
+
+// History returns the changes made to an appointment, oldest first.
+// Appointments booked before the audit log existed have no history.
+func (r *SQLRepository) History(ctx context.Context, id string) (entries []AuditEntry, err error) {
+	ctx, span := tracer.Start(ctx, "SQLRepository.History")
+	defer func() { tracing.End(span, err) }()
+
+	const Query = `
+SELECT action, actor, role, request_id, recorded_at, before, after
+  FROM %s
+ WHERE tenant_id = :tenant_id
+   AND appointment_id = :appointment_id
+ ORDER BY seq
+`
+
+	formattedQuery := fmt.Sprintf(Query, r.AuditTable())
+	err = tracing.Query(ctx, tracer, r.AuditTable(), "SELECT", formattedQuery, func(ctx context.Context) error {
+		rows, err := r.Database.QueryContext(ctx, formattedQuery,
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("appointment_id", id))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				entry         = AuditEntry{AppointmentID: id}
+				action        string
+				at            int64
+				before, after sql.NullString
+			)
+			err := rows.Scan(&action, &entry.Source.Actor, &entry.Source.Role, &entry.Source.RequestID, &at, &before, &after)
+			if err != nil {
+				return err
+			}
+
+			entry.Action, entry.At = AuditAction(action), time.Unix(at, 0)
+			if entry.Before, err = fromSnapshot(before); err != nil {
+				return err
+			}
+
+			if entry.After, err = fromSnapshot(after); err != nil {
+				return err
+			}
+
+			entries = append(entries, entry)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Appends a change to the audit log, in the transaction making it, so the
+// log never misses a change or records one that didn't happen.
+func (r *SQLRepository) writeAudit(ctx context.Context, txn *sql.Tx, action AuditAction, before, after *Appointment) error {
+	const Insert = `
+INSERT INTO %s(tenant_id, appointment_id, action, actor, role, request_id, recorded_at, before, after)
+     VALUES (:tenant_id, :appointment_id, :action, :actor, :role, :request_id, :recorded_at, :before, :after)
+`
+
+	beforeJSON, err := toSnapshot(before)
+	if err != nil {
+		return err
+	}
+
+	afterJSON, err := toSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	source := audit.FromContext(ctx)
+	formattedInsert := fmt.Sprintf(Insert, r.AuditTable())
+
+	return tracing.Query(ctx, tracer, r.AuditTable(), "INSERT", formattedInsert, func(ctx context.Context) error {
+		_, err := txn.ExecContext(ctx, formattedInsert,
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("appointment_id", after.ID),
+			sql.Named("action", string(action)),
+			sql.Named("actor", source.Actor),
+			sql.Named("role", source.Role),
+			sql.Named("request_id", source.RequestID),
+			sql.Named("recorded_at", time.Now().Unix()),
+			sql.Named("before", beforeJSON),
+			sql.Named("after", afterJSON))
+		return err
+	})
+}
+
+func toSnapshot(apt *Appointment) (sql.NullString, error) {
+	if apt == nil {
+		return sql.NullString{}, nil
+	}
+
+	raw, err := jsoniter.MarshalToString(snapshot{
+		ID:          apt.ID,
+		TrainerID:   apt.TrainerID,
+		UserID:      apt.UserID,
+		Start:       apt.Start.UTC(),
+		End:         apt.End.UTC(),
+		Status:      apt.Status,
+		Version:     apt.Version,
+		ResourceIDs: apt.ResourceIDs,
+		Notes:       apt.Notes,
+		Metadata:    apt.Metadata,
+	})
+
+	return sql.NullString{String: raw, Valid: err == nil}, err
+}
+
+func fromSnapshot(raw sql.NullString) (*Appointment, error) {
+	if !raw.Valid {
+		return nil, nil
+	}
+
+	var s snapshot
+	if err := jsoniter.UnmarshalFromString(raw.String, &s); err != nil {
+		return nil, err
+	}
+
+	return &Appointment{
+		ID:          s.ID,
+		TrainerID:   s.TrainerID,
+		UserID:      s.UserID,
+		Start:       s.Start,
+		End:         s.End,
+		Status:      s.Status,
+		Version:     s.Version,
+		ResourceIDs: s.ResourceIDs,
+		Notes:       s.Notes,
+		Metadata:    s.Metadata,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package appointment
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/standoffvenus/future/internal/tenant"
+	"github.com/standoffvenus/future/internal/tracing"
+)
+
+// Events written to the outbox for other systems, such as billing, to react
+// to. Their data is the appointment after the change.
+const (
+	EventCreated     = "AppointmentCreated"
+	EventRescheduled = "AppointmentRescheduled"
+	EventCancelled   = "AppointmentCancelled"
+)
+
+// OutboxTable holds events until they're delivered, see the outbox package.
+func (r *SQLRepository) OutboxTable() string {
+	return r.Table + "_outbox"
+}
+
+// Changes to notes and metadata alone aren't events.
+func eventFor(before, after Appointment) string {
+	switch {
+	case after.Status == StatusCancelled && before.Status != StatusCancelled:
+		return EventCancelled
+	case !after.Start.Equal(before.Start) || !after.End.Equal(before.End):
+		return EventRescheduled
+	}
+
+	return ""
+}
+
+// Appends an event to the outbox, in the transaction making the change, so
+// an event is sent if and only if its change is committed.
+func (r *SQLRepository) writeEvent(ctx context.Context, txn *sql.Tx, eventType string, apt Appointment) error {
+	const Insert = `
+INSERT INTO %s(id, tenant_id, type, appointment_id, occurred_at, data)
+     VALUES (:id, :tenant_id, :type, :appointment_id, :occurred_at, :data)
+`
+
+	data, err := toSnapshot(&apt)
+	if err != nil {
+		return err
+	}
+
+	formattedInsert := fmt.Sprintf(Insert, r.OutboxTable())
+
+	return tracing.Query(ctx, tracer, r.OutboxTable(), "INSERT", formattedInsert, func(ctx context.Context) error {
+		_, err := txn.ExecContext(ctx, formattedInsert,
+			sql.Named("id", uuid.NewString()),
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("type", eventType),
+			sql.Named("appointment_id", apt.ID),
+			sql.Named("occurred_at", time.Now().Unix()),
+			sql.Named("data", data.String))
+		return err
+	})
+}
@@ -0,0 +1,87 @@
+package appointment
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reasons Create may reject an appointment for, as counted by Metrics.
+const (
+	RejectedInvalidDateRange     = "invalid_date_range"
+	RejectedOutsideBusinessHours = "outside_business_hours"
+	RejectedScheduleConflict     = "schedule_conflict"
+	RejectedIDTaken              = "id_taken"
+	RejectedUnknownTrainer       = "unknown_trainer"
+	RejectedInactiveTrainer      = "inactive_trainer"
+	RejectedUnknownMember        = "unknown_member"
+	RejectedInactiveMember       = "inactive_member"
+	RejectedResourceConflict     = "resource_conflict"
+	RejectedUnknownResource      = "unknown_resource"
+	RejectedInactiveResource     = "inactive_resource"
+	RejectedError                = "error"
+)
+
+// Metrics counts the appointments Create books and those it rejects.
+type Metrics struct {
+	Created  prometheus.Counter
+	Rejected *prometheus.CounterVec
+}
+
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	factory := promauto.With(registerer)
+
+	return &Metrics{
+		Created: factory.NewCounter(prometheus.CounterOpts{
+			Name: "appointments_created_total",
+			Help: "Appointments booked.",
+		}),
+		Rejected: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "appointments_rejected_total",
+			Help: "Appointments not booked, by reason.",
+		}, []string{"reason"}),
+	}
+}
+
+func (m *Metrics) recordCreate(err error) {
+	if m == nil {
+		return
+	}
+
+	if err == nil {
+		m.Created.Inc()
+		return
+	}
+
+	m.Rejected.WithLabelValues(rejectionReason(err)).Inc()
+}
+
+func rejectionReason(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidDateRange):
+		return RejectedInvalidDateRange
+	case errors.Is(err, ErrOutsideBusinessHours):
+		return RejectedOutsideBusinessHours
+	case errors.Is(err, ErrScheduleConflict):
+		return RejectedScheduleConflict
+	case errors.Is(err, ErrIDTaken):
+		return RejectedIDTaken
+	case errors.Is(err, ErrUnknownTrainer):
+		return RejectedUnknownTrainer
+	case errors.Is(err, ErrInactiveTrainer):
+		return RejectedInactiveTrainer
+	case errors.Is(err, ErrUnknownMember):
+		return RejectedUnknownMember
+	case errors.Is(err, ErrInactiveMember):
+		return RejectedInactiveMember
+	case errors.Is(err, ErrResourceConflict):
+		return RejectedResourceConflict
+	case errors.Is(err, ErrUnknownResource):
+		return RejectedUnknownResource
+	case errors.Is(err, ErrInactiveResource):
+		return RejectedInactiveResource
+	}
+
+	return RejectedError
+}
@@ -5,17 +5,35 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/mattn/go-sqlite3"
+	"github.com/standoffvenus/future/internal/application"
+	"github.com/standoffvenus/future/internal/tenant"
+	"github.com/standoffvenus/future/internal/tracing"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/standoffvenus/future/internal/appointment")
+
 type Repository interface {
-	GetByTrainer(context.Context, string) ([]Appointment, error)
-	GetByTrainerAndDate(context.Context, string, Range) ([]Appointment, error)
-	Create(context.Context, Appointment) error
+	GetByTrainer(context.Context, string, Filter, Page) (Listing, error)
+	GetByTrainerAndDate(context.Context, string, Range, Filter, Page) (Listing, error)
+	GetByResource(context.Context, string, Filter, Page) (Listing, error)
+	GetByResourceAndDate(context.Context, string, Range, Filter, Page) (Listing, error)
+	Get(context.Context, string) (Appointment, error)
+	Create(context.Context, Appointment) (Appointment, error)
+	Update(context.Context, Appointment, int64) (Appointment, error)
+	History(context.Context, string) ([]AuditEntry, error)
 }
 
+// SQLRepository keeps every tenant's appointments in one table, and only
+// ever reads or changes those of the tenant in the context it's given. The
+// resources each appointment claims and its metadata are kept in tables of
+// their own, see ClaimsTable and MetadataTable. Every change is recorded in
+// AuditTable, and those other systems care about are written to OutboxTable.
 type SQLRepository struct {
 	Database *sql.DB
 	Table    string
@@ -24,6 +42,51 @@ type SQLRepository struct {
 type Range struct {
 	Start time.Time
 	End   time.Time
+	Mode  RangeMode
+}
+
+// RangeMode decides which appointments a Range matches: those overlapping it
+// at all, or only those that fall entirely within it.
+type RangeMode int
+
+const (
+	RangeOverlap RangeMode = iota
+	RangeContained
+)
+
+type Filter struct {
+	UserID   string
+	Status   Status
+	Upcoming bool
+
+	// Metadata matches appointments with every one of these keys set to
+	// its value.
+	Metadata map[string]string
+}
+
+type Sort int
+
+const (
+	SortStartAscending Sort = iota
+	SortStartDescending
+)
+
+type Page struct {
+	Limit int
+	After *Cursor
+	Sort  Sort
+}
+
+// Cursor identifies the last appointment of a page; appointments are
+// ordered by start time, with ties broken by ID, in the page's direction.
+type Cursor struct {
+	Start time.Time
+	ID    string
+}
+
+type Listing struct {
+	Appointments []Appointment
+	Next         *Cursor
 }
 
 type entity struct {
@@ -32,6 +95,9 @@ type entity struct {
 	UserID    string
 	Start     int64
 	End       int64
+	Status    string
+	Version   int64
+	Notes     string
 }
 
 var _ Repository = new(SQLRepository)
@@ -40,105 +106,835 @@ type rowScanner interface {
 	Scan(dest ...any) error
 }
 
-func (r *SQLRepository) GetByTrainer(ctx context.Context, trainerID string) ([]Appointment, error) {
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type querier interface {
+	rowQuerier
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// ClaimsTable holds which resources each appointment claims.
+func (r *SQLRepository) ClaimsTable() string {
+	return r.Table + "_resource_claims"
+}
+
+// MetadataTable holds each appointment's metadata, a row per key.
+func (r *SQLRepository) MetadataTable() string {
+	return r.Table + "_metadata"
+}
+
+func (r *SQLRepository) Get(ctx context.Context, id string) (Appointment, error) {
+	return r.get(ctx, r.Database, id)
+}
+
+func (r *SQLRepository) GetByTrainer(ctx context.Context, trainerID string, filter Filter, page Page) (Listing, error) {
 	const Query = `
-SELECT id, trainer_id, user_id, starts_at, ends_at
+SELECT id, trainer_id, user_id, starts_at, ends_at, status, version, notes
   FROM %s
- WHERE trainer_id = :trainer_id
+ WHERE tenant_id = :tenant_id
+   AND trainer_id = :trainer_id
+   %s
+   %s
 `
 
-	formattedQuery := fmt.Sprintf(Query, r.Table)
-	rows, err := r.Database.QueryContext(ctx, formattedQuery, sql.Named("trainer_id", trainerID))
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	filters, filterArgs := r.filterClause(filter)
+	clause, pageArgs := pageClause(page)
+	formattedQuery := fmt.Sprintf(Query, r.Table, filters, clause)
+	args := append(append(filterArgs, pageArgs...),
+		sql.Named("tenant_id", tenant.FromContext(ctx)),
+		sql.Named("trainer_id", trainerID))
+
+	return r.queryPage(ctx, formattedQuery, args, page)
+}
+
+func (r *SQLRepository) GetByTrainerAndDate(
+	ctx context.Context,
+	trainerID string,
+	times Range,
+	filter Filter,
+	page Page,
+) (Listing, error) {
+	query, args := r.TrainerRangeQuery(ctx, trainerID, times, filter, page)
 
-	return scanAll(rows)
+	return r.queryPage(ctx, query, args, page)
 }
 
-func (r *SQLRepository) GetByTrainerAndDate(ctx context.Context, trainerID string, times Range) ([]Appointment, error) {
+// TrainerRangeQuery is the statement GetByTrainerAndDate runs, with its
+// arguments, so its plan can be inspected.
+func (r *SQLRepository) TrainerRangeQuery(
+	ctx context.Context,
+	trainerID string,
+	times Range,
+	filter Filter,
+	page Page,
+) (string, []any) {
 	const Query = `
-SELECT id, trainer_id, user_id, starts_at, ends_at
+SELECT id, trainer_id, user_id, starts_at, ends_at, status, version, notes
   FROM %s
- WHERE trainer_id = :trainer_id
-   AND starts_at >= :start
-   AND ends_at <= :end
+ WHERE tenant_id = :tenant_id
+   AND trainer_id = :trainer_id
+   %s
+   %s
+   %s
 `
 
-	formattedQuery := fmt.Sprintf(Query, r.Table)
-	rows, err := r.Database.QueryContext(ctx, formattedQuery,
-		sql.Named("trainer_id", trainerID),
-		sql.Named("start", times.Start.Unix()),
-		sql.Named("end", times.End.Unix()))
+	window, rangeArgs := rangeClause(times)
+	filters, filterArgs := r.filterClause(filter)
+	clause, pageArgs := pageClause(page)
+	formattedQuery := fmt.Sprintf(Query, r.Table, window, filters, clause)
+	args := append(append(append(rangeArgs, filterArgs...), pageArgs...),
+		sql.Named("tenant_id", tenant.FromContext(ctx)),
+		sql.Named("trainer_id", trainerID))
+
+	return formattedQuery, args
+}
+
+func (r *SQLRepository) GetByResource(ctx context.Context, resourceID string, filter Filter, page Page) (Listing, error) {
+	const Query = `
+SELECT id, trainer_id, user_id, starts_at, ends_at, status, version, notes
+  FROM %s
+ WHERE tenant_id = :tenant_id
+   AND id IN (SELECT appointment_id
+                FROM %s
+               WHERE tenant_id = :tenant_id
+                 AND resource_id = :resource_id)
+   %s
+   %s
+`
+
+	filters, filterArgs := r.filterClause(filter)
+	clause, pageArgs := pageClause(page)
+	formattedQuery := fmt.Sprintf(Query, r.Table, r.ClaimsTable(), filters, clause)
+	args := append(append(filterArgs, pageArgs...),
+		sql.Named("tenant_id", tenant.FromContext(ctx)),
+		sql.Named("resource_id", resourceID))
+
+	return r.queryPage(ctx, formattedQuery, args, page)
+}
+
+func (r *SQLRepository) GetByResourceAndDate(
+	ctx context.Context,
+	resourceID string,
+	times Range,
+	filter Filter,
+	page Page,
+) (Listing, error) {
+	const Query = `
+SELECT id, trainer_id, user_id, starts_at, ends_at, status, version, notes
+  FROM %s
+ WHERE tenant_id = :tenant_id
+   AND id IN (SELECT appointment_id
+                FROM %s
+               WHERE tenant_id = :tenant_id
+                 AND resource_id = :resource_id)
+   %s
+   %s
+   %s
+`
+
+	window, rangeArgs := rangeClause(times)
+	filters, filterArgs := r.filterClause(filter)
+	clause, pageArgs := pageClause(page)
+	formattedQuery := fmt.Sprintf(Query, r.Table, r.ClaimsTable(), window, filters, clause)
+	args := append(append(append(rangeArgs, filterArgs...), pageArgs...),
+		sql.Named("tenant_id", tenant.FromContext(ctx)),
+		sql.Named("resource_id", resourceID))
+
+	return r.queryPage(ctx, formattedQuery, args, page)
+}
+
+func (r *SQLRepository) queryPage(ctx context.Context, query string, args []any, page Page) (Listing, error) {
+	var listing Listing
+	err := tracing.Query(ctx, tracer, r.Table, "SELECT", query, func(ctx context.Context) error {
+		rows, err := r.Database.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		listing, err = scanPage(rows, page)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return Listing{}, err
 	}
-	defer rows.Close()
 
-	return scanAll(rows)
+	if err := r.loadDetails(ctx, r.Database, listing.Appointments); err != nil {
+		return Listing{}, err
+	}
+
+	return listing, nil
 }
 
-func (r *SQLRepository) Create(ctx context.Context, apt Appointment) error {
-	txn, err := r.Database.BeginTx(ctx, nil)
+func (r *SQLRepository) Create(ctx context.Context, apt Appointment) (created Appointment, err error) {
+	ctx, span := tracer.Start(ctx, "SQLRepository.Create")
+	defer func() { tracing.End(span, err) }()
+
+	txn, err := r.begin(ctx)
 	if err != nil {
-		return err
+		return Appointment{}, err
 	}
 	defer txn.Rollback()
 
-	if n, err := r.countAppointments(ctx, txn, apt); err != nil {
-		return err
-	} else if n > 0 {
-		return ErrScheduleConflict
+	// A retried create should learn its ID is taken, not that it conflicts
+	// with itself.
+	if exists, err := r.exists(ctx, txn, apt.ID); err != nil {
+		return Appointment{}, err
+	} else if exists {
+		return Appointment{}, ErrIDTaken
+	}
+
+	if err := r.ensureAvailable(ctx, txn, apt); err != nil {
+		return Appointment{}, err
 	}
 
 	const Insert = `
-INSERT INTO %s(id, trainer_id, user_id, starts_at, ends_at)
-	 VALUES (:id, :trainer_id, :user_id, :start, :end)
+INSERT INTO %s(id, tenant_id, trainer_id, user_id, starts_at, ends_at, status, version, notes)
+	 VALUES (:id, :tenant_id, :trainer_id, :user_id, :start, :end, :status, 1, :notes)
 `
 
 	formattedInsert := fmt.Sprintf(Insert, r.Table)
-	_, err = txn.ExecContext(ctx, formattedInsert,
-		sql.Named("id", apt.ID),
-		sql.Named("trainer_id", apt.TrainerID),
-		sql.Named("user_id", apt.UserID),
-		sql.Named("start", apt.Start.Unix()),
-		sql.Named("end", apt.End.Unix()))
+	err = tracing.Query(ctx, tracer, r.Table, "INSERT", formattedInsert, func(ctx context.Context) error {
+		_, err := txn.ExecContext(ctx, formattedInsert,
+			sql.Named("id", apt.ID),
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("trainer_id", apt.TrainerID),
+			sql.Named("user_id", apt.UserID),
+			sql.Named("start", apt.Start.Unix()),
+			sql.Named("end", apt.End.Unix()),
+			sql.Named("status", string(apt.Status)),
+			sql.Named("notes", apt.Notes))
+		return err
+	})
 	if err != nil {
 		// TODO: This is not portable to other SQL DB's.
-		if isSQLiteError(err, sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintUnique) {
-			return ErrIDTaken
+		if application.IsSQLiteError(err, sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintUnique) {
+			return Appointment{}, ErrIDTaken
 		}
 
+		return Appointment{}, err
+	}
+
+	if err := r.insertClaims(ctx, txn, apt); err != nil {
+		return Appointment{}, err
+	}
+
+	if err := r.insertMetadata(ctx, txn, apt); err != nil {
+		return Appointment{}, err
+	}
+
+	apt.Version = 1
+	if err := r.writeAudit(ctx, txn, AuditCreate, nil, &apt); err != nil {
+		return Appointment{}, err
+	}
+
+	if err := r.writeEvent(ctx, txn, EventCreated, apt); err != nil {
+		return Appointment{}, err
+	}
+
+	return apt, r.commit(ctx, txn)
+}
+
+// Update stores the appointment's times, status, notes and metadata if it's
+// still at the given version, returning it at its next version. Its trainer,
+// user and resources never change.
+func (r *SQLRepository) Update(ctx context.Context, apt Appointment, version int64) (updated Appointment, err error) {
+	ctx, span := tracer.Start(ctx, "SQLRepository.Update")
+	defer func() { tracing.End(span, err) }()
+
+	txn, err := r.begin(ctx)
+	if err != nil {
+		return Appointment{}, err
+	}
+	defer txn.Rollback()
+
+	current, err := r.get(ctx, txn, apt.ID)
+	if err != nil {
+		return Appointment{}, err
+	}
+
+	if current.Version != version {
+		return Appointment{}, fmt.Errorf("%w: expected version %d, found %d", ErrVersionMismatch, version, current.Version)
+	}
+
+	before := current
+	metadataChanged := !sameMetadata(current.Metadata, apt.Metadata)
+	current.Start, current.End, current.Status = apt.Start, apt.End, apt.Status
+	current.Notes, current.Metadata = apt.Notes, apt.Metadata
+	if current.Status == StatusScheduled {
+		if err := r.ensureAvailable(ctx, txn, current); err != nil {
+			return Appointment{}, err
+		}
+	}
+
+	const Update = `
+UPDATE %s
+   SET starts_at = :start,
+       ends_at = :end,
+       status = :status,
+       notes = :notes,
+       version = version + 1
+ WHERE id = :id
+   AND tenant_id = :tenant_id
+   AND version = :version
+`
+
+	formattedUpdate := fmt.Sprintf(Update, r.Table)
+	err = tracing.Query(ctx, tracer, r.Table, "UPDATE", formattedUpdate, func(ctx context.Context) error {
+		_, err := txn.ExecContext(ctx, formattedUpdate,
+			sql.Named("start", current.Start.Unix()),
+			sql.Named("end", current.End.Unix()),
+			sql.Named("status", string(current.Status)),
+			sql.Named("notes", current.Notes),
+			sql.Named("id", current.ID),
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("version", version))
 		return err
+	})
+	if err != nil {
+		return Appointment{}, err
+	}
+
+	if metadataChanged {
+		if err := r.deleteMetadata(ctx, txn, current.ID); err != nil {
+			return Appointment{}, err
+		}
+
+		if err := r.insertMetadata(ctx, txn, current); err != nil {
+			return Appointment{}, err
+		}
 	}
 
-	return txn.Commit()
+	current.Version = version + 1
+
+	action := AuditUpdate
+	if current.Status == StatusCancelled && before.Status != StatusCancelled {
+		action = AuditCancel
+	}
+
+	if err := r.writeAudit(ctx, txn, action, &before, &current); err != nil {
+		return Appointment{}, err
+	}
+
+	if event := eventFor(before, current); event != "" {
+		if err := r.writeEvent(ctx, txn, event, current); err != nil {
+			return Appointment{}, err
+		}
+	}
+
+	return current, r.commit(ctx, txn)
 }
 
-func (r *SQLRepository) countAppointments(ctx context.Context, txn *sql.Tx, apt Appointment) (int64, error) {
+// Transactions take SQLite's write lock as they begin, so time spent waiting
+// on other writers shows up in the BEGIN span.
+func (r *SQLRepository) begin(ctx context.Context) (*sql.Tx, error) {
+	var txn *sql.Tx
+	err := tracing.Query(ctx, tracer, r.Table, "BEGIN", "BEGIN IMMEDIATE", func(ctx context.Context) error {
+		var err error
+		txn, err = r.Database.BeginTx(ctx, nil)
+		return err
+	})
+
+	return txn, err
+}
+
+func (r *SQLRepository) commit(ctx context.Context, txn *sql.Tx) error {
+	return tracing.Query(ctx, tracer, r.Table, "COMMIT", "COMMIT", func(context.Context) error {
+		return txn.Commit()
+	})
+}
+
+// Runs fn, which executes and reads the results of a single statement, in
+// its own span. Finding no rows isn't an error worth marking a span with.
+func (r *SQLRepository) get(ctx context.Context, q querier, id string) (Appointment, error) {
 	const Query = `
-SELECT COUNT(*) AS c
+SELECT id, trainer_id, user_id, starts_at, ends_at, status, version, notes
   FROM %s
- WHERE trainer_id = :trainer_id
-   AND starts_at >= :start
-   AND ends_at <= :end
+ WHERE id = :id
+   AND tenant_id = :tenant_id
 `
 
 	formattedQuery := fmt.Sprintf(Query, r.Table)
-	row := txn.QueryRowContext(ctx, formattedQuery,
-		sql.Named("trainer_id", apt.TrainerID),
-		sql.Named("start", apt.Start.Unix()),
-		sql.Named("end", apt.End.Unix()))
+
+	var apt Appointment
+	err := tracing.Query(ctx, tracer, r.Table, "SELECT", formattedQuery, func(ctx context.Context) error {
+		var err error
+		apt, err = scanRow(q.QueryRowContext(ctx, formattedQuery,
+			sql.Named("id", id),
+			sql.Named("tenant_id", tenant.FromContext(ctx))))
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return Appointment{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	} else if err != nil {
+		return Appointment{}, err
+	}
+
+	apts := []Appointment{apt}
+	if err := r.loadDetails(ctx, q, apts); err != nil {
+		return Appointment{}, err
+	}
+
+	return apts[0], nil
+}
+
+func (r *SQLRepository) exists(ctx context.Context, txn *sql.Tx, id string) (bool, error) {
+	const Query = `
+SELECT 1
+  FROM %s
+ WHERE id = :id
+   AND tenant_id = :tenant_id
+`
+
+	formattedQuery := fmt.Sprintf(Query, r.Table)
+
+	var found int
+	err := tracing.Query(ctx, tracer, r.Table, "SELECT", formattedQuery, func(ctx context.Context) error {
+		return txn.QueryRowContext(ctx, formattedQuery,
+			sql.Named("id", id),
+			sql.Named("tenant_id", tenant.FromContext(ctx))).Scan(&found)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *SQLRepository) countAppointments(ctx context.Context, txn *sql.Tx, apt Appointment) (int64, error) {
+	query, args := r.ConflictQuery(ctx, apt)
 
 	var count int64
-	if err := row.Scan(&count); err != nil && !errors.Is(err, sql.ErrNoRows) {
+	err := tracing.Query(ctx, tracer, r.Table, "SELECT", query, func(ctx context.Context) error {
+		return txn.QueryRowContext(ctx, query, args...).Scan(&count)
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return 0, err
 	}
 
 	return count, nil
 }
 
+// ConflictQuery is the statement counting the trainer's scheduled
+// appointments that overlap apt, with its arguments, so its plan can be
+// inspected.
+func (r *SQLRepository) ConflictQuery(ctx context.Context, apt Appointment) (string, []any) {
+	const Query = `
+SELECT COUNT(*) AS c
+  FROM %s
+ WHERE tenant_id = :tenant_id
+   AND trainer_id = :trainer_id
+   AND id != :id
+   AND status = :status
+   %s
+`
+
+	// Any overlap with another scheduled appointment is a conflict, not
+	// only an identical slot.
+	window, args := rangeClause(Range{Start: apt.Start, End: apt.End, Mode: RangeOverlap})
+	formattedQuery := fmt.Sprintf(Query, r.Table, window)
+	args = append(args,
+		sql.Named("tenant_id", tenant.FromContext(ctx)),
+		sql.Named("trainer_id", apt.TrainerID),
+		sql.Named("id", apt.ID),
+		sql.Named("status", string(StatusScheduled)))
+
+	return formattedQuery, args
+}
+
+// The trainer and every resource the appointment claims must be free for
+// its whole time. All of them are checked in the caller's transaction, so
+// an appointment is booked with everything it needs or not at all.
+func (r *SQLRepository) ensureAvailable(ctx context.Context, txn *sql.Tx, apt Appointment) error {
+	if n, err := r.countAppointments(ctx, txn, apt); err != nil {
+		return err
+	} else if n > 0 {
+		return ErrScheduleConflict
+	}
+
+	if len(apt.ResourceIDs) == 0 {
+		return nil
+	}
+
+	const Query = `
+SELECT c.resource_id
+  FROM %s AS c
+  JOIN %s AS a ON a.id = c.appointment_id AND a.tenant_id = c.tenant_id
+ WHERE c.tenant_id = :tenant_id
+   AND c.resource_id IN (%s)
+   AND a.id != :id
+   AND a.status = :status
+   %s
+ LIMIT 1
+`
+
+	resources, resourceArgs := inList("resource", apt.ResourceIDs)
+	window, rangeArgs := rangeClause(Range{Start: apt.Start, End: apt.End, Mode: RangeOverlap})
+	formattedQuery := fmt.Sprintf(Query, r.ClaimsTable(), r.Table, resources, window)
+	args := append(append(resourceArgs, rangeArgs...),
+		sql.Named("tenant_id", tenant.FromContext(ctx)),
+		sql.Named("id", apt.ID),
+		sql.Named("status", string(StatusScheduled)))
+
+	var claimed string
+	err := tracing.Query(ctx, tracer, r.Table, "SELECT", formattedQuery, func(ctx context.Context) error {
+		return txn.QueryRowContext(ctx, formattedQuery, args...).Scan(&claimed)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s", ErrResourceConflict, claimed)
+}
+
+func (r *SQLRepository) insertClaims(ctx context.Context, txn *sql.Tx, apt Appointment) error {
+	const Insert = `
+INSERT INTO %s(tenant_id, appointment_id, resource_id)
+     VALUES (:tenant_id, :appointment_id, :resource_id)
+`
+
+	formattedInsert := fmt.Sprintf(Insert, r.ClaimsTable())
+	for _, resourceID := range apt.ResourceIDs {
+		err := tracing.Query(ctx, tracer, r.Table, "INSERT", formattedInsert, func(ctx context.Context) error {
+			_, err := txn.ExecContext(ctx, formattedInsert,
+				sql.Named("tenant_id", tenant.FromContext(ctx)),
+				sql.Named("appointment_id", apt.ID),
+				sql.Named("resource_id", resourceID))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *SQLRepository) insertMetadata(ctx context.Context, txn *sql.Tx, apt Appointment) error {
+	const Insert = `
+INSERT INTO %s(tenant_id, appointment_id, key, value)
+     VALUES (:tenant_id, :appointment_id, :key, :value)
+`
+
+	formattedInsert := fmt.Sprintf(Insert, r.MetadataTable())
+	for key, value := range apt.Metadata {
+		err := tracing.Query(ctx, tracer, r.Table, "INSERT", formattedInsert, func(ctx context.Context) error {
+			_, err := txn.ExecContext(ctx, formattedInsert,
+				sql.Named("tenant_id", tenant.FromContext(ctx)),
+				sql.Named("appointment_id", apt.ID),
+				sql.Named("key", key),
+				sql.Named("value", value))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *SQLRepository) deleteMetadata(ctx context.Context, txn *sql.Tx, id string) error {
+	const Delete = `
+DELETE FROM %s
+ WHERE tenant_id = :tenant_id
+   AND appointment_id = :appointment_id
+`
+
+	formattedDelete := fmt.Sprintf(Delete, r.MetadataTable())
+
+	return tracing.Query(ctx, tracer, r.Table, "DELETE", formattedDelete, func(ctx context.Context) error {
+		_, err := txn.ExecContext(ctx, formattedDelete,
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("appointment_id", id))
+		return err
+	})
+}
+
+// Fills in the resources and metadata of each appointment, with one query
+// per table however many appointments there are.
+func (r *SQLRepository) loadDetails(ctx context.Context, q querier, apts []Appointment) error {
+	if len(apts) == 0 {
+		return nil
+	}
+
+	if err := r.loadClaims(ctx, q, apts); err != nil {
+		return err
+	}
+
+	return r.loadMetadata(ctx, q, apts)
+}
+
+// Fills in the resources each appointment claims, in the order they were
+// claimed.
+func (r *SQLRepository) loadClaims(ctx context.Context, q querier, apts []Appointment) error {
+	const Query = `
+SELECT appointment_id, resource_id
+  FROM %s
+ WHERE tenant_id = :tenant_id
+   AND appointment_id IN (%s)
+ ORDER BY rowid
+`
+
+	appointments, args := inList("appointment", appointmentIDs(apts))
+	formattedQuery := fmt.Sprintf(Query, r.ClaimsTable(), appointments)
+	args = append(args, sql.Named("tenant_id", tenant.FromContext(ctx)))
+
+	claims := make(map[string][]string)
+	err := tracing.Query(ctx, tracer, r.Table, "SELECT", formattedQuery, func(ctx context.Context) error {
+		rows, err := q.QueryContext(ctx, formattedQuery, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var appointmentID, resourceID string
+			if err := rows.Scan(&appointmentID, &resourceID); err != nil {
+				return err
+			}
+
+			claims[appointmentID] = append(claims[appointmentID], resourceID)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range apts {
+		apts[i].ResourceIDs = claims[apts[i].ID]
+	}
+
+	return nil
+}
+
+func (r *SQLRepository) loadMetadata(ctx context.Context, q querier, apts []Appointment) error {
+	const Query = `
+SELECT appointment_id, key, value
+  FROM %s
+ WHERE tenant_id = :tenant_id
+   AND appointment_id IN (%s)
+`
+
+	appointments, args := inList("appointment", appointmentIDs(apts))
+	formattedQuery := fmt.Sprintf(Query, r.MetadataTable(), appointments)
+	args = append(args, sql.Named("tenant_id", tenant.FromContext(ctx)))
+
+	metadata := make(map[string]map[string]string)
+	err := tracing.Query(ctx, tracer, r.Table, "SELECT", formattedQuery, func(ctx context.Context) error {
+		rows, err := q.QueryContext(ctx, formattedQuery, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var appointmentID, key, value string
+			if err := rows.Scan(&appointmentID, &key, &value); err != nil {
+				return err
+			}
+
+			if metadata[appointmentID] == nil {
+				metadata[appointmentID] = make(map[string]string)
+			}
+			metadata[appointmentID][key] = value
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range apts {
+		apts[i].Metadata = metadata[apts[i].ID]
+	}
+
+	return nil
+}
+
+func appointmentIDs(apts []Appointment) []string {
+	ids := make([]string, 0, len(apts))
+	for _, apt := range apts {
+		ids = append(ids, apt.ID)
+	}
+
+	return ids
+}
+
+// Metadata without any keys is the same, whether it's nil or empty.
+func sameMetadata(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Binds each value as its own parameter, named after prefix, for use in an
+// IN (...) list.
+func inList(prefix string, values []string) (string, []any) {
+	names := make([]string, 0, len(values))
+	args := make([]any, 0, len(values))
+	for i, v := range values {
+		name := fmt.Sprintf("%s_%d", prefix, i)
+		names = append(names, ":"+name)
+		args = append(args, sql.Named(name, v))
+	}
+
+	return strings.Join(names, ", "), args
+}
+
+// Restricts a query to the range's window. Overlapping appointments are
+// also bounded below by the earliest they could start, so only that stretch
+// of the (tenant_id, trainer_id, starts_at) index is searched, however long
+// the trainer's history.
+func rangeClause(times Range) (string, []any) {
+	args := []any{
+		sql.Named("start", times.Start.Unix()),
+		sql.Named("end", times.End.Unix()),
+	}
+
+	if times.Mode == RangeContained {
+		return `
+   AND starts_at >= :start
+   AND ends_at <= :end`, args
+	}
+
+	return `
+   AND starts_at < :end
+   AND starts_at > :earliest_start
+   AND ends_at > :start`, append(args, sql.Named("earliest_start", times.Start.Add(-MaxLengthOfAppointment).Unix()))
+}
+
+// Extends a query's WHERE clause with the filter's conditions. Only fixed
+// SQL fragments are added; every value is bound as a parameter.
+func (r *SQLRepository) filterClause(filter Filter) (string, []any) {
+	const Metadata = `
+   AND id IN (SELECT appointment_id
+                FROM %s
+               WHERE tenant_id = :tenant_id
+                 AND key = :%s
+                 AND value = :%s)`
+
+	var (
+		clause string
+		args   []any
+	)
+	if filter.UserID != "" {
+		clause += `
+   AND user_id = :user_id`
+		args = append(args, sql.Named("user_id", filter.UserID))
+	}
+
+	if filter.Status != "" {
+		clause += `
+   AND status = :status`
+		args = append(args, sql.Named("status", string(filter.Status)))
+	}
+
+	if filter.Upcoming {
+		clause += `
+   AND starts_at >= :now`
+		args = append(args, sql.Named("now", time.Now().Unix()))
+	}
+
+	// Sorted, so the same filter always makes the same statement.
+	keys := make([]string, 0, len(filter.Metadata))
+	for key := range filter.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		keyName, valueName := fmt.Sprintf("metadata_key_%d", i), fmt.Sprintf("metadata_value_%d", i)
+		clause += fmt.Sprintf(Metadata, r.MetadataTable(), keyName, valueName)
+		args = append(args, sql.Named(keyName, key), sql.Named(valueName, filter.Metadata[key]))
+	}
+
+	return clause, args
+}
+
+// Completes a query's WHERE clause with the page's cursor, ordering and limit.
+// One row more than the limit is selected so scanPage can tell whether
+// another page follows; a page without a limit selects every row.
+func pageClause(page Page) (string, []any) {
+	const (
+		AfterAscending = `
+   AND (starts_at > :cursor_start OR (starts_at = :cursor_start AND id > :cursor_id))`
+		AfterDescending = `
+   AND (starts_at < :cursor_start OR (starts_at = :cursor_start AND id < :cursor_id))`
+		OrderAscending = `
+ ORDER BY starts_at, id`
+		OrderDescending = `
+ ORDER BY starts_at DESC, id DESC`
+		Limit = `
+ LIMIT :limit`
+	)
+
+	after, order := AfterAscending, OrderAscending
+	if page.Sort == SortStartDescending {
+		after, order = AfterDescending, OrderDescending
+	}
+
+	var (
+		clause string
+		args   []any
+	)
+	if page.After != nil {
+		clause += after
+		args = append(args,
+			sql.Named("cursor_start", page.After.Start.Unix()),
+			sql.Named("cursor_id", page.After.ID))
+	}
+
+	clause += order
+	if page.Limit > 0 {
+		clause += Limit
+		args = append(args, sql.Named("limit", page.Limit+1))
+	}
+
+	return clause, args
+}
+
+func scanPage(rows *sql.Rows, page Page) (Listing, error) {
+	apts, err := scanAll(rows)
+	if err != nil {
+		return Listing{}, err
+	}
+
+	if page.Limit <= 0 || len(apts) <= page.Limit {
+		return Listing{Appointments: apts}, nil
+	}
+
+	apts = apts[:page.Limit]
+	last := apts[len(apts)-1]
+
+	return Listing{
+		Appointments: apts,
+		Next: &Cursor{
+			Start: last.Start,
+			ID:    last.ID,
+		},
+	}, nil
+}
+
 func scanAll(rows *sql.Rows) ([]Appointment, error) {
 	apts := make([]Appointment, 0, 16)
 	for rows.Next() {
@@ -157,19 +953,6 @@ func scanAll(rows *sql.Rows) ([]Appointment, error) {
 	return apts, nil
 }
 
-func isSQLiteError(err error, codes ...sqlite3.ErrNoExtended) bool {
-	var sqliteErr sqlite3.Error
-	if errors.As(err, &sqliteErr) {
-		for _, c := range codes {
-			if sqliteErr.ExtendedCode == c {
-				return true
-			}
-		}
-	}
-
-	return false
-}
-
 func scanRow(r rowScanner) (Appointment, error) {
 	var ent entity
 	err := r.Scan(
@@ -177,7 +960,10 @@ func scanRow(r rowScanner) (Appointment, error) {
 		&ent.TrainerID,
 		&ent.UserID,
 		&ent.Start,
-		&ent.End)
+		&ent.End,
+		&ent.Status,
+		&ent.Version,
+		&ent.Notes)
 
 	return entityToAppointment(ent), err
 }
@@ -189,5 +975,8 @@ func entityToAppointment(ent entity) Appointment {
 		UserID:    ent.UserID,
 		Start:     time.Unix(ent.Start, 0),
 		End:       time.Unix(ent.End, 0),
+		Status:    Status(ent.Status),
+		Version:   ent.Version,
+		Notes:     ent.Notes,
 	}
 }
@@ -0,0 +1,242 @@
+package appointment_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/standoffvenus/future/internal/application"
+	"github.com/standoffvenus/future/internal/appointment"
+	"github.com/standoffvenus/future/internal/tenant"
+)
+
+var (
+	benchRows     = flag.Int("bench.rows", 100_000, "Sets the number of appointments seeded before each benchmark")
+	benchTrainers = flag.Int("bench.trainers", 10, "Sets the number of trainers the seeded appointments are spread across")
+)
+
+const length = 30 * time.Minute
+
+// Seeded appointments are laid out back to back for every trainer, starting here.
+var epoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Races creates for the same trainer and slot: exactly one must be booked,
+// and the rest must conflict with it rather than fail on the locked database.
+func TestCreateConcurrent(t *testing.T) {
+	const (
+		Slots       = 20
+		Concurrency = 16
+	)
+
+	// Each create is a handful of short cgo calls, which a single P runs one
+	// after another; more Ps than CPUs make the creates really overlap.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(Concurrency))
+
+	ctx := context.Background()
+	repository := openRepository(t)
+
+	for slot := 0; slot < Slots; slot++ {
+		start := slotTime(slot)
+
+		// Every create waits on ready, so they all start together.
+		var (
+			wg    sync.WaitGroup
+			ready = make(chan struct{})
+			errs  = make([]error, Concurrency)
+		)
+		for i := 0; i < Concurrency; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				<-ready
+				_, errs[i] = repository.Create(ctx, appointment.Appointment{
+					ID:        fmt.Sprintf("race-%d-%d", slot, i),
+					TrainerID: "trainer",
+					UserID:    strconv.Itoa(i),
+					Start:     start,
+					End:       start.Add(length),
+					Status:    appointment.StatusScheduled,
+				})
+			}(i)
+		}
+		close(ready)
+		wg.Wait()
+
+		booked := 0
+		for _, err := range errs {
+			var sqliteErr sqlite3.Error
+			switch {
+			case err == nil:
+				booked++
+			case errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrBusy:
+				t.Fatalf("slot %d: create failed on a busy database: %v", slot, err)
+			case !errors.Is(err, appointment.ErrScheduleConflict):
+				t.Fatalf("slot %d: expected %v, got %v", slot, appointment.ErrScheduleConflict, err)
+			}
+		}
+
+		if booked != 1 {
+			t.Fatalf("slot %d: booked %d times, expected once", slot, booked)
+		}
+	}
+}
+
+// IDs are only unique within a tenant, so booking one in another tenant
+// mustn't reveal that it's taken there.
+func TestCreateIDPerTenant(t *testing.T) {
+	repository := openRepository(t)
+	apt := appointment.Appointment{
+		ID:        "shared",
+		TrainerID: "trainer",
+		UserID:    "user",
+		Start:     epoch,
+		End:       epoch.Add(length),
+		Status:    appointment.StatusScheduled,
+	}
+
+	downtown := tenant.WithID(context.Background(), "downtown")
+	uptown := tenant.WithID(context.Background(), "uptown")
+	if _, err := repository.Create(downtown, apt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repository.Create(uptown, apt); err != nil {
+		t.Fatalf("expected the ID to be free in another tenant, got %v", err)
+	}
+
+	apt.Start, apt.End = apt.Start.Add(time.Hour), apt.End.Add(time.Hour)
+	if _, err := repository.Create(downtown, apt); !errors.Is(err, appointment.ErrIDTaken) {
+		t.Fatalf("expected %v, got %v", appointment.ErrIDTaken, err)
+	}
+}
+
+func BenchmarkCreate(b *testing.B) {
+	ctx := context.Background()
+	repository := openRepository(b)
+	seed(b, repository, *benchRows, *benchTrainers)
+
+	// Creates are placed after the seeded appointments, and after those of
+	// earlier runs, so they never conflict.
+	created := 0
+	first := slotTime(*benchRows / *benchTrainers + 1)
+	b.Run(fmt.Sprintf("rows=%d", *benchRows), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			start := first.Add(time.Duration(created/(*benchTrainers)) * length)
+			apt := appointment.Appointment{
+				ID:        fmt.Sprintf("bench-%d", created),
+				TrainerID: strconv.Itoa(created % *benchTrainers),
+				UserID:    "bench",
+				Start:     start,
+				End:       start.Add(length),
+				Status:    appointment.StatusScheduled,
+			}
+			created++
+
+			if _, err := repository.Create(ctx, apt); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetByTrainerAndDate(b *testing.B) {
+	ctx := context.Background()
+	repository := openRepository(b)
+	seed(b, repository, *benchRows, *benchTrainers)
+
+	rng := rand.New(rand.NewSource(1))
+	slotsPerTrainer := *benchRows / *benchTrainers
+	b.Run(fmt.Sprintf("rows=%d", *benchRows), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			start := slotTime(rng.Intn(slotsPerTrainer))
+			times := appointment.Range{
+				Start: start,
+				End:   start.Add(24 * time.Hour),
+			}
+
+			trainerID := strconv.Itoa(rng.Intn(*benchTrainers))
+			if _, err := repository.GetByTrainerAndDate(ctx, trainerID, times, appointment.Filter{}, appointment.Page{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Opens a repository on a new database file, which is removed once the test
+// or benchmark is done.
+func openRepository(tb testing.TB) *appointment.SQLRepository {
+	tb.Helper()
+
+	ctx := context.Background()
+	db, err := application.OpenSQLite3DB(ctx, filepath.Join(tb.TempDir(), "test.sqlite3"), 5*time.Second)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	repository := &appointment.SQLRepository{
+		Database: db,
+		Table:    "appointments",
+	}
+	if err := repository.CreateSchema(ctx); err != nil {
+		tb.Fatal(err)
+	}
+
+	return repository
+}
+
+// Inserts rows appointments directly, in one transaction, so seeding doesn't
+// take longer than the benchmark itself.
+func seed(tb testing.TB, repository *appointment.SQLRepository, rows, trainers int) {
+	tb.Helper()
+
+	const Insert = `
+INSERT INTO %s(id, trainer_id, user_id, starts_at, ends_at)
+     VALUES (:id, :trainer_id, :user_id, :start, :end)
+`
+
+	ctx := context.Background()
+	txn, err := repository.Database.BeginTx(ctx, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer txn.Rollback()
+
+	stmt, err := txn.PrepareContext(ctx, fmt.Sprintf(Insert, repository.Table))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer stmt.Close()
+
+	for i := 0; i < rows; i++ {
+		start := slotTime(i / trainers)
+		_, err := stmt.ExecContext(ctx,
+			sql.Named("id", fmt.Sprintf("seed-%d", i)),
+			sql.Named("trainer_id", strconv.Itoa(i%trainers)),
+			sql.Named("user_id", strconv.Itoa(i%(trainers*10))),
+			sql.Named("start", start.Unix()),
+			sql.Named("end", start.Add(length).Unix()))
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	if err := txn.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func slotTime(slot int) time.Time {
+	return epoch.Add(time.Duration(slot) * length)
+}
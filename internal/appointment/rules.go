@@ -0,0 +1,49 @@
+package appointment
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// MaxLengthOfAppointment is the longest any appointment may be, so finding
+// those that overlap a window only searches back this far from its start.
+const MaxLengthOfAppointment = 24 * time.Hour
+
+type BusinessHours struct {
+	Location *time.Location
+	Start    int
+	End      int
+}
+
+// Rules are what appointments are checked against when they're booked or
+// looked up.
+type Rules struct {
+	LengthOfAppointment time.Duration
+	MaxRangeLength      time.Duration
+	BusinessHours
+}
+
+// RuleSet holds the rules each tenant's appointments follow, and can have
+// them replaced while the service is serving requests. Each request reads
+// its rules once, so it never sees half of an update.
+type RuleSet struct {
+	rules atomic.Value
+}
+
+func NewRuleSet(rules map[string]Rules) *RuleSet {
+	var set RuleSet
+	set.Store(rules)
+
+	return &set
+}
+
+// Load returns the tenant's rules, or false if there's no such tenant.
+func (s *RuleSet) Load(tenant string) (Rules, bool) {
+	rules, ok := s.rules.Load().(map[string]Rules)[tenant]
+	return rules, ok
+}
+
+// Store replaces every tenant's rules. The map must not be changed after.
+func (s *RuleSet) Store(rules map[string]Rules) {
+	s.rules.Store(rules)
+}
@@ -0,0 +1,372 @@
+package appointment
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Migrations are applied in order and never edited once released; the
+// number applied is tracked with SQLite's user_version pragma. Each
+// statement is formatted with the repository's table name.
+var migrations = [][]string{
+	{
+		`
+CREATE TABLE IF NOT EXISTS %[1]s(
+    id         TEXT PRIMARY KEY,
+    trainer_id TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    starts_at  INTEGER NOT NULL,
+    ends_at    INTEGER NOT NULL
+)
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_trainer_id_starts_at
+    ON %[1]s(trainer_id, starts_at)
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_user_id_starts_at
+    ON %[1]s(user_id, starts_at)
+`,
+	},
+	{
+		`
+ALTER TABLE %[1]s
+  ADD COLUMN status TEXT NOT NULL DEFAULT 'scheduled'
+`,
+	},
+	{
+		// Idempotency keys for creating appointments, see the idempotency
+		// package. A key is claimed before its request runs, and its status
+		// code stays 0 until the response is stored.
+		`
+CREATE TABLE IF NOT EXISTS %[1]s_idempotency_keys(
+    scope        TEXT NOT NULL,
+    key          TEXT NOT NULL,
+    request_hash BLOB NOT NULL,
+    status_code  INTEGER NOT NULL DEFAULT 0,
+    headers      TEXT NOT NULL DEFAULT '{}',
+    body         BLOB,
+    expires_at   INTEGER NOT NULL,
+    PRIMARY KEY (scope, key)
+)
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_idempotency_keys_expires_at
+    ON %[1]s_idempotency_keys(expires_at)
+`,
+	},
+	{
+		`
+ALTER TABLE %[1]s
+  ADD COLUMN version INTEGER NOT NULL DEFAULT 1
+`,
+	},
+	{
+		// Appointments from before tenants belong to the default tenant.
+		`
+ALTER TABLE %[1]s
+  ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default'
+`,
+		`
+DROP INDEX IF EXISTS %[1]s_trainer_id_starts_at
+`,
+		`
+DROP INDEX IF EXISTS %[1]s_user_id_starts_at
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_tenant_id_trainer_id_starts_at
+    ON %[1]s(tenant_id, trainer_id, starts_at)
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_tenant_id_user_id_starts_at
+    ON %[1]s(tenant_id, user_id, starts_at)
+`,
+	},
+	{
+		// The trainers and members appointments are booked for, see the
+		// directory package.
+		`
+CREATE TABLE IF NOT EXISTS %[1]s_trainers(
+    tenant_id    TEXT NOT NULL,
+    id           TEXT NOT NULL,
+    display_name TEXT NOT NULL,
+    time_zone    TEXT NOT NULL,
+    active       INTEGER NOT NULL DEFAULT 1,
+    PRIMARY KEY (tenant_id, id)
+)
+`,
+		`
+CREATE TABLE IF NOT EXISTS %[1]s_members(
+    tenant_id    TEXT NOT NULL,
+    id           TEXT NOT NULL,
+    display_name TEXT NOT NULL,
+    time_zone    TEXT NOT NULL,
+    active       INTEGER NOT NULL DEFAULT 1,
+    PRIMARY KEY (tenant_id, id)
+)
+`,
+	},
+	{
+		// Rooms and equipment, which have calendars of their own. An
+		// appointment claims any number of them for its whole time.
+		`
+CREATE TABLE IF NOT EXISTS %[1]s_resources(
+    tenant_id    TEXT NOT NULL,
+    id           TEXT NOT NULL,
+    display_name TEXT NOT NULL,
+    time_zone    TEXT NOT NULL,
+    active       INTEGER NOT NULL DEFAULT 1,
+    PRIMARY KEY (tenant_id, id)
+)
+`,
+		`
+CREATE TABLE IF NOT EXISTS %[1]s_resource_claims(
+    tenant_id      TEXT NOT NULL,
+    appointment_id TEXT NOT NULL,
+    resource_id    TEXT NOT NULL,
+    PRIMARY KEY (tenant_id, appointment_id, resource_id)
+)
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_resource_claims_tenant_id_resource_id
+    ON %[1]s_resource_claims(tenant_id, resource_id)
+`,
+	},
+	{
+		`
+ALTER TABLE %[1]s
+  ADD COLUMN notes TEXT NOT NULL DEFAULT ''
+`,
+		// Metadata is kept a row per key, so listings can be filtered by it.
+		`
+CREATE TABLE IF NOT EXISTS %[1]s_metadata(
+    tenant_id      TEXT NOT NULL,
+    appointment_id TEXT NOT NULL,
+    key            TEXT NOT NULL,
+    value          TEXT NOT NULL,
+    PRIMARY KEY (tenant_id, appointment_id, key)
+)
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_metadata_tenant_id_key_value
+    ON %[1]s_metadata(tenant_id, key, value)
+`,
+	},
+	{
+		// The audit log of every appointment's changes. Snapshots of the
+		// appointment before and after each change are JSON, and before is
+		// NULL for the change that created it.
+		`
+CREATE TABLE IF NOT EXISTS %[1]s_audit(
+    seq            INTEGER PRIMARY KEY AUTOINCREMENT,
+    tenant_id      TEXT NOT NULL,
+    appointment_id TEXT NOT NULL,
+    action         TEXT NOT NULL,
+    actor          TEXT NOT NULL,
+    role           TEXT NOT NULL,
+    request_id     TEXT NOT NULL,
+    recorded_at    INTEGER NOT NULL,
+    before         TEXT,
+    after          TEXT NOT NULL
+)
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_audit_tenant_id_appointment_id
+    ON %[1]s_audit(tenant_id, appointment_id, seq)
+`,
+		// Entries are only ever appended.
+		`
+CREATE TRIGGER IF NOT EXISTS %[1]s_audit_no_update
+BEFORE UPDATE ON %[1]s_audit
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END
+`,
+		`
+CREATE TRIGGER IF NOT EXISTS %[1]s_audit_no_delete
+BEFORE DELETE ON %[1]s_audit
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END
+`,
+	},
+	{
+		// Events for other systems, see the outbox package. They're written
+		// with the change they describe, and delivered_at is set once every
+		// sink has them.
+		`
+CREATE TABLE IF NOT EXISTS %[1]s_outbox(
+    seq            INTEGER PRIMARY KEY AUTOINCREMENT,
+    id             TEXT NOT NULL UNIQUE,
+    tenant_id      TEXT NOT NULL,
+    type           TEXT NOT NULL,
+    appointment_id TEXT NOT NULL,
+    occurred_at    INTEGER NOT NULL,
+    data           TEXT NOT NULL,
+    attempts       INTEGER NOT NULL DEFAULT 0,
+    delivered_at   INTEGER
+)
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_outbox_pending
+    ON %[1]s_outbox(seq)
+ WHERE delivered_at IS NULL
+`,
+	},
+	{
+		// Appointment IDs are unique within each tenant rather than across
+		// them all, so one tenant's IDs say nothing of another's. SQLite
+		// can't change a table's primary key, so it's rebuilt.
+		`
+CREATE TABLE %[1]s_rebuilt(
+    id         TEXT NOT NULL,
+    trainer_id TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    starts_at  INTEGER NOT NULL,
+    ends_at    INTEGER NOT NULL,
+    status     TEXT NOT NULL DEFAULT 'scheduled',
+    version    INTEGER NOT NULL DEFAULT 1,
+    tenant_id  TEXT NOT NULL DEFAULT 'default',
+    notes      TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (tenant_id, id)
+)
+`,
+		`
+INSERT INTO %[1]s_rebuilt(id, trainer_id, user_id, starts_at, ends_at, status, version, tenant_id, notes)
+     SELECT id, trainer_id, user_id, starts_at, ends_at, status, version, tenant_id, notes
+       FROM %[1]s
+`,
+		`
+DROP TABLE %[1]s
+`,
+		`
+ALTER TABLE %[1]s_rebuilt
+  RENAME TO %[1]s
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_tenant_id_trainer_id_starts_at
+    ON %[1]s(tenant_id, trainer_id, starts_at)
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_tenant_id_user_id_starts_at
+    ON %[1]s(tenant_id, user_id, starts_at)
+`,
+	},
+	{
+		// Events that fail every attempt allowed are marked failed, rather
+		// than holding back those after them forever, and kept so they can
+		// be inspected and retried.
+		`
+ALTER TABLE %[1]s_outbox
+  ADD COLUMN failed_at INTEGER
+`,
+		`
+DROP INDEX IF EXISTS %[1]s_outbox_pending
+`,
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_outbox_pending
+    ON %[1]s_outbox(seq)
+ WHERE delivered_at IS NULL
+   AND failed_at IS NULL
+`,
+		// Delivered events are removed once they're older than the
+		// configured retention.
+		`
+CREATE INDEX IF NOT EXISTS %[1]s_outbox_delivered_at
+    ON %[1]s_outbox(delivered_at)
+ WHERE delivered_at IS NOT NULL
+`,
+	},
+	{
+		// A claimed key is only held for its request until its lease runs
+		// out, so a retry can take over from a request that never finished.
+		// Claims from before leases have already run out.
+		`
+ALTER TABLE %[1]s_idempotency_keys
+  ADD COLUMN leased_until INTEGER NOT NULL DEFAULT 0
+`,
+	},
+}
+
+func (r *SQLRepository) CreateSchema(ctx context.Context) error {
+	txn, err := r.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	version, err := schemaVersion(ctx, txn)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations[version:] {
+		for _, stmt := range migration {
+			if _, err := txn.ExecContext(ctx, fmt.Sprintf(stmt, r.Table)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Pragmas can't be parameterized.
+	if _, err := txn.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+		return err
+	}
+
+	if err := r.checkTable(ctx, txn); err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// CheckSchema fails unless every migration this build knows of, and no
+// others, has been applied.
+func (r *SQLRepository) CheckSchema(ctx context.Context) error {
+	version, err := schemaVersion(ctx, r.Database)
+	if err != nil {
+		return err
+	}
+
+	if version != len(migrations) {
+		return fmt.Errorf("database schema version %d is older than this build expects (%d)", version, len(migrations))
+	}
+
+	return r.checkTable(ctx, r.Database)
+}
+
+// The schema version belongs to the whole database, so a database migrated
+// under another table name looks up to date without having the table.
+func (r *SQLRepository) checkTable(ctx context.Context, q rowQuerier) error {
+	const Query = `
+SELECT COUNT(*)
+  FROM sqlite_master
+ WHERE type = 'table'
+   AND name = :name
+`
+
+	var count int
+	if err := q.QueryRowContext(ctx, Query, sql.Named("name", r.Table)).Scan(&count); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return fmt.Errorf("database schema is up to date but has no %s table; was it created with a different table name?", r.Table)
+	}
+
+	return nil
+}
+
+func schemaVersion(ctx context.Context, q rowQuerier) (int, error) {
+	var version int
+	if err := q.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return 0, err
+	}
+
+	if version > len(migrations) {
+		return 0, fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, len(migrations))
+	}
+
+	return version, nil
+}
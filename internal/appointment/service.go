@@ -7,7 +7,10 @@ import (
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/standoffvenus/future/internal/directory"
 	"github.com/standoffvenus/future/internal/empty"
+	"github.com/standoffvenus/future/internal/tenant"
+	"github.com/standoffvenus/future/internal/tracing"
 )
 
 var loc = time.UTC
@@ -17,69 +20,344 @@ var (
 	ErrInvalidDateRange     = errors.New("supplied times are invalid")
 	ErrOutsideBusinessHours = errors.New("proposed time outside business hours")
 	ErrScheduleConflict     = errors.New("time not available")
+	ErrResourceConflict     = errors.New("resource not available at that time")
 	ErrNoTrainerID          = errors.New("no trainer ID supplied")
+	ErrNoResourceID         = errors.New("no resource ID supplied")
+	ErrInvalidPage          = errors.New("invalid page")
+	ErrInvalidFilter        = errors.New("invalid filter")
+	ErrNotFound             = errors.New("appointment not found")
+	ErrVersionMismatch      = errors.New("appointment has changed since it was read")
+	ErrCancelled            = errors.New("appointment is cancelled")
+	ErrUnknownTenant        = errors.New("unknown tenant")
+	ErrUnknownTrainer       = errors.New("trainer not found")
+	ErrInactiveTrainer      = errors.New("trainer is inactive")
+	ErrUnknownMember        = errors.New("member not found")
+	ErrInactiveMember       = errors.New("member is inactive")
+	ErrUnknownResource      = errors.New("resource not found")
+	ErrInactiveResource     = errors.New("resource is inactive")
 )
 
-type BusinessHours struct {
-	Location *time.Location
-	Start    int
-	End      int
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// Directory looks up the trainers, members or resources appointments are
+// booked for.
+type Directory interface {
+	Get(context.Context, string) (directory.Entry, error)
 }
 
 type Service struct {
-	Repository          Repository
-	LengthOfAppointment time.Duration
-	BusinessHours
+	Repository Repository
+	Rules      *RuleSet
+
+	// Trainers, Members and Resources, if set, are checked by Create, which
+	// only books appointments for their active entries.
+	Trainers  Directory
+	Members   Directory
+	Resources Directory
+
+	// Metrics, if set, counts the outcome of every Create.
+	Metrics *Metrics
 }
 
-func (s *Service) Create(ctx context.Context, apt Appointment) error {
-	if err := s.ensureValidCreateTimes(apt.Start, apt.End); err != nil {
-		return err
+func (s *Service) Create(ctx context.Context, apt Appointment) (created Appointment, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Create")
+	defer func() {
+		s.Metrics.recordCreate(err)
+		tracing.End(span, err)
+	}()
+
+	if err := s.validate(ctx, func() error { return s.ensureValidCreateTimes(ctx, apt.Start, apt.End) }); err != nil {
+		return Appointment{}, err
 	}
 
-	if err := s.Repository.Create(ctx, apt); err != nil {
-		return err
+	if err := s.ensureBookable(ctx, apt); err != nil {
+		return Appointment{}, err
 	}
 
-	return nil
+	apt.Status = StatusScheduled
+
+	return s.Repository.Create(ctx, apt)
+}
+
+func (s *Service) Get(ctx context.Context, id string) (apt Appointment, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Get")
+	defer func() { tracing.End(span, err) }()
+
+	return s.Repository.Get(ctx, id)
+}
+
+// Changes are what Update changes about an appointment; anything left zero
+// or nil stays as it is. Start and End are changed together, and an empty,
+// non-nil Metadata removes every key.
+type Changes struct {
+	Start    time.Time
+	End      time.Time
+	Notes    *string
+	Metadata map[string]string
+}
+
+// Update reschedules an appointment or changes its notes and metadata, if
+// it's still at the given version.
+func (s *Service) Update(ctx context.Context, id string, changes Changes, version int64) (updated Appointment, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Update")
+	defer func() { tracing.End(span, err) }()
+
+	rescheduling := !changes.Start.IsZero() || !changes.End.IsZero()
+	if rescheduling {
+		if err := s.validate(ctx, func() error { return s.ensureValidCreateTimes(ctx, changes.Start, changes.End) }); err != nil {
+			return Appointment{}, err
+		}
+	}
+
+	apt, err := s.scheduled(ctx, id)
+	if err != nil {
+		return Appointment{}, err
+	}
+
+	if rescheduling {
+		apt.Start, apt.End = changes.Start, changes.End
+	}
+
+	if changes.Notes != nil {
+		apt.Notes = *changes.Notes
+	}
+
+	if changes.Metadata != nil {
+		apt.Metadata = changes.Metadata
+	}
+
+	return s.Repository.Update(ctx, apt, version)
+}
+
+// Cancel cancels an appointment, if it's still at the given version, freeing
+// its time for other bookings.
+func (s *Service) Cancel(ctx context.Context, id string, version int64) (cancelled Appointment, err error) {
+	ctx, span := tracer.Start(ctx, "Service.Cancel")
+	defer func() { tracing.End(span, err) }()
+
+	apt, err := s.scheduled(ctx, id)
+	if err != nil {
+		return Appointment{}, err
+	}
+
+	apt.Status = StatusCancelled
+
+	return s.Repository.Update(ctx, apt, version)
 }
 
-func (s *Service) FindByTrainerIDInRange(ctx context.Context, trainerID string, timeRange Range) ([]Appointment, error) {
+// History returns the changes made to an appointment, oldest first.
+func (s *Service) History(ctx context.Context, id string) (entries []AuditEntry, err error) {
+	ctx, span := tracer.Start(ctx, "Service.History")
+	defer func() { tracing.End(span, err) }()
+
+	return s.Repository.History(ctx, id)
+}
+
+func (s *Service) FindByTrainerIDInRange(
+	ctx context.Context,
+	trainerID string,
+	timeRange Range,
+	filter Filter,
+	page Page,
+) (listing Listing, err error) {
+	ctx, span := tracer.Start(ctx, "Service.FindByTrainerIDInRange")
+	defer func() { tracing.End(span, err) }()
+
 	if empty.String(trainerID) {
-		return []Appointment{}, ErrNoTrainerID
+		return Listing{}, ErrNoTrainerID
 	}
 
-	if err := s.ensureValidGetTimes(timeRange.Start, timeRange.End); err != nil {
-		return []Appointment{}, err
+	err = s.validate(ctx, func() error {
+		if err := s.ensureValidGetTimes(ctx, timeRange); err != nil {
+			return err
+		}
+
+		if err := ensureValidFilter(filter); err != nil {
+			return err
+		}
+
+		var err error
+		page, err = ensureValidPage(page)
+		return err
+	})
+	if err != nil {
+		return Listing{}, err
 	}
 
-	apts, err := s.Repository.GetByTrainerAndDate(ctx, trainerID, timeRange)
+	return s.Repository.GetByTrainerAndDate(ctx, trainerID, timeRange, filter, page)
+}
+
+func (s *Service) FindByResourceIDInRange(
+	ctx context.Context,
+	resourceID string,
+	timeRange Range,
+	filter Filter,
+	page Page,
+) (listing Listing, err error) {
+	ctx, span := tracer.Start(ctx, "Service.FindByResourceIDInRange")
+	defer func() { tracing.End(span, err) }()
+
+	if empty.String(resourceID) {
+		return Listing{}, ErrNoResourceID
+	}
+
+	err = s.validate(ctx, func() error {
+		if err := s.ensureValidGetTimes(ctx, timeRange); err != nil {
+			return err
+		}
+
+		if err := ensureValidFilter(filter); err != nil {
+			return err
+		}
+
+		var err error
+		page, err = ensureValidPage(page)
+		return err
+	})
 	if err != nil {
-		return []Appointment{}, err
+		return Listing{}, err
 	}
 
-	return apts, nil
+	return s.Repository.GetByResourceAndDate(ctx, resourceID, timeRange, filter, page)
 }
 
-func (s *Service) FindByTrainerID(ctx context.Context, trainerID string) ([]Appointment, error) {
+func (s *Service) FindByResourceID(ctx context.Context, resourceID string, filter Filter, page Page) (listing Listing, err error) {
+	ctx, span := tracer.Start(ctx, "Service.FindByResourceID")
+	defer func() { tracing.End(span, err) }()
+
+	if empty.String(resourceID) {
+		return Listing{}, ErrNoResourceID
+	}
+
+	err = s.validate(ctx, func() error {
+		if err := ensureValidFilter(filter); err != nil {
+			return err
+		}
+
+		var err error
+		page, err = ensureValidPage(page)
+		return err
+	})
+	if err != nil {
+		return Listing{}, err
+	}
+
+	return s.Repository.GetByResource(ctx, resourceID, filter, page)
+}
+
+func (s *Service) FindByTrainerID(ctx context.Context, trainerID string, filter Filter, page Page) (listing Listing, err error) {
+	ctx, span := tracer.Start(ctx, "Service.FindByTrainerID")
+	defer func() { tracing.End(span, err) }()
+
 	if empty.String(trainerID) {
-		return []Appointment{}, ErrNoTrainerID
+		return Listing{}, ErrNoTrainerID
 	}
 
-	apts, err := s.Repository.GetByTrainer(ctx, trainerID)
+	err = s.validate(ctx, func() error {
+		if err := ensureValidFilter(filter); err != nil {
+			return err
+		}
+
+		var err error
+		page, err = ensureValidPage(page)
+		return err
+	})
 	if err != nil {
-		return []Appointment{}, err
+		return Listing{}, err
+	}
+
+	return s.Repository.GetByTrainer(ctx, trainerID, filter, page)
+}
+
+// Runs fn's checks in their own span, so time spent validating can be told
+// apart from time spent in the repository.
+func (s *Service) validate(ctx context.Context, fn func() error) error {
+	_, span := tracer.Start(ctx, "Service.validate")
+	err := fn()
+	tracing.End(span, err)
+
+	return err
+}
+
+func (s *Service) ensureBookable(ctx context.Context, apt Appointment) error {
+	if s.Trainers != nil {
+		if err := ensureActive(ctx, s.Trainers, apt.TrainerID, ErrUnknownTrainer, ErrInactiveTrainer); err != nil {
+			return err
+		}
+	}
+
+	if s.Members != nil {
+		if err := ensureActive(ctx, s.Members, apt.UserID, ErrUnknownMember, ErrInactiveMember); err != nil {
+			return err
+		}
+	}
+
+	if s.Resources != nil {
+		for _, id := range apt.ResourceIDs {
+			if err := ensureActive(ctx, s.Resources, id, ErrUnknownResource, ErrInactiveResource); err != nil {
+				return err
+			}
+		}
 	}
 
-	return apts, nil
+	return nil
 }
 
-func (s *Service) ensureValidCreateTimes(start, end time.Time) error {
+func ensureActive(ctx context.Context, d Directory, id string, errUnknown, errInactive error) error {
+	entry, err := d.Get(ctx, id)
+	if errors.Is(err, directory.ErrNotFound) {
+		return fmt.Errorf("%w: %s", errUnknown, id)
+	} else if err != nil {
+		return err
+	}
+
+	if !entry.Active {
+		return fmt.Errorf("%w: %s", errInactive, id)
+	}
+
+	return nil
+}
+
+// Cancelled appointments can't be changed.
+func (s *Service) scheduled(ctx context.Context, id string) (Appointment, error) {
+	apt, err := s.Repository.Get(ctx, id)
+	if err != nil {
+		return Appointment{}, err
+	}
+
+	if apt.Status == StatusCancelled {
+		return Appointment{}, fmt.Errorf("%w: %s", ErrCancelled, id)
+	}
+
+	return apt, nil
+}
+
+// Each tenant has its own rules, so a gym's appointments follow its own
+// hours and time zone.
+func (s *Service) rules(ctx context.Context) (Rules, error) {
+	id := tenant.FromContext(ctx)
+	rules, ok := s.Rules.Load(id)
+	if !ok {
+		return Rules{}, fmt.Errorf("%w: %s", ErrUnknownTenant, id)
+	}
+
+	return rules, nil
+}
+
+func (s *Service) ensureValidCreateTimes(ctx context.Context, start, end time.Time) error {
 	if err := s.ensureValidTimes(start, end); err != nil {
 		return err
 	}
 
-	if expectedEnd := start.Add(s.LengthOfAppointment); !expectedEnd.Equal(end) {
+	rules, err := s.rules(ctx)
+	if err != nil {
+		return err
+	}
+
+	if expectedEnd := start.Add(rules.LengthOfAppointment); !expectedEnd.Equal(end) {
 		log.
 			Debug().
 			Fields(map[string]any{
@@ -89,7 +367,7 @@ func (s *Service) ensureValidCreateTimes(start, end time.Time) error {
 			}).
 			Msg("Invalid appointment length from consumer.")
 
-		return fmt.Errorf("%w: invalid appointment length (must be %s)", ErrInvalidDateRange, s.LengthOfAppointment)
+		return fmt.Errorf("%w: invalid appointment length (must be %s)", ErrInvalidDateRange, rules.LengthOfAppointment)
 	}
 
 	if start.Minute()%30 != 0 {
@@ -100,19 +378,36 @@ func (s *Service) ensureValidCreateTimes(start, end time.Time) error {
 		return fmt.Errorf("%w: appointment for the past", ErrInvalidDateRange)
 	}
 
-	if start.In(s.BusinessHours.Location).Hour() < s.BusinessHours.Start ||
-		end.In(s.BusinessHours.Location).Hour() > s.BusinessHours.End {
+	if start.In(rules.Location).Hour() < rules.Start ||
+		end.In(rules.Location).Hour() > rules.End {
 		return ErrOutsideBusinessHours
 	}
 
 	return nil
 }
 
-func (s *Service) ensureValidGetTimes(start, end time.Time) error {
-	if err := s.ensureValidTimes(start, end); err != nil {
+func (s *Service) ensureValidGetTimes(ctx context.Context, timeRange Range) error {
+	if err := s.ensureValidTimes(timeRange.Start, timeRange.End); err != nil {
 		return err
 	}
 
+	rules, err := s.rules(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !timeRange.Start.Before(timeRange.End) {
+		return fmt.Errorf("%w: start must be before end", ErrInvalidDateRange)
+	}
+
+	if limit := rules.MaxRangeLength; limit > 0 && timeRange.End.Sub(timeRange.Start) > limit {
+		return fmt.Errorf("%w: range may span at most %s", ErrInvalidDateRange, limit)
+	}
+
+	if timeRange.Mode != RangeOverlap && timeRange.Mode != RangeContained {
+		return fmt.Errorf("%w: unknown range mode", ErrInvalidDateRange)
+	}
+
 	return nil
 }
 
@@ -127,3 +422,27 @@ func (s *Service) ensureValidTimes(start, end time.Time) error {
 
 	return nil
 }
+
+func ensureValidPage(page Page) (Page, error) {
+	if page.Limit == 0 {
+		page.Limit = DefaultPageLimit
+	}
+
+	if page.Limit < 0 || page.Limit > MaxPageLimit {
+		return Page{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidPage, MaxPageLimit)
+	}
+
+	if page.Sort != SortStartAscending && page.Sort != SortStartDescending {
+		return Page{}, fmt.Errorf("%w: unknown sort order", ErrInvalidPage)
+	}
+
+	return page, nil
+}
+
+func ensureValidFilter(filter Filter) error {
+	if filter.Status != "" && !filter.Status.Valid() {
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidFilter, filter.Status)
+	}
+
+	return nil
+}
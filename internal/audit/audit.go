@@ -0,0 +1,24 @@
+package audit
+
+import "context"
+
+// Source is who made a change, and the request they made it in. Any of it
+// may be empty, such as the actor when authentication is disabled.
+type Source struct {
+	Actor     string
+	Role      string
+	RequestID string
+}
+
+type key struct{}
+
+// WithSource returns a context whose changes are recorded as made by source.
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, key{}, source)
+}
+
+// FromContext returns who the context's changes are made by.
+func FromContext(ctx context.Context) Source {
+	source, _ := ctx.Value(key{}).(Source)
+	return source
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// APIKeys maps the SHA-256 digest of each key to its owner, so lookups never
+// compare the raw keys themselves.
+type APIKeys map[[sha256.Size]byte]Identity
+
+type apiKeyEntry struct {
+	Key     string `json:"key"`
+	Subject string `json:"subject"`
+	Role    Role   `json:"role"`
+	Tenant  string `json:"tenant"`
+}
+
+// LoadAPIKeys reads a JSON array of {"key", "subject", "role", "tenant"}
+// objects.
+func LoadAPIKeys(file string) (APIKeys, error) {
+	fileBytes, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []apiKeyEntry
+	if err := jsoniter.Unmarshal(fileBytes, &entries); err != nil {
+		return nil, fmt.Errorf("auth: could not parse API keys in %q: %w", file, err)
+	}
+
+	keys := make(APIKeys, len(entries))
+	for i, e := range entries {
+		if len(e.Key) < 16 {
+			return nil, fmt.Errorf("auth: API key %d in %q is shorter than 16 characters", i, file)
+		}
+
+		identity, err := newIdentity(e.Subject, e.Role, e.Tenant)
+		if err != nil {
+			return nil, fmt.Errorf("auth: API key %d in %q: %w", i, file, err)
+		}
+
+		keys[sha256.Sum256([]byte(e.Key))] = identity
+	}
+
+	return keys, nil
+}
+
+func (k APIKeys) Lookup(key string) (Identity, error) {
+	identity, ok := k[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: unknown API key", ErrInvalidCredentials)
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoCredentials      = errors.New("no credentials supplied")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+type Role string
+
+const (
+	RoleMember  Role = "member"
+	RoleTrainer Role = "trainer"
+	RoleAdmin   Role = "admin"
+)
+
+// Identity is the authenticated caller. For members, Subject is their user ID;
+// for trainers, their trainer ID. Callers with a Tenant may only act within
+// that tenant; see AuthorizeTenant for those without.
+type Identity struct {
+	Subject string
+	Role    Role
+	Tenant  string
+}
+
+type Authenticator struct {
+	APIKeys APIKeys
+	Tokens  *TokenVerifier
+}
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleMember, RoleTrainer, RoleAdmin:
+		return true
+	}
+
+	return false
+}
+
+func (a *Authenticator) Enabled() bool {
+	return len(a.APIKeys) > 0 || a.Tokens != nil
+}
+
+func (a *Authenticator) AuthenticateAPIKey(key string) (Identity, error) {
+	if len(a.APIKeys) == 0 {
+		return Identity{}, fmt.Errorf("%w: API keys are not accepted", ErrInvalidCredentials)
+	}
+
+	return a.APIKeys.Lookup(key)
+}
+
+func (a *Authenticator) AuthenticateBearerToken(token string) (Identity, error) {
+	if a.Tokens == nil {
+		return Identity{}, fmt.Errorf("%w: bearer tokens are not accepted", ErrInvalidCredentials)
+	}
+
+	return a.Tokens.Verify(token)
+}
+
+func newIdentity(subject string, role Role, tenant string) (Identity, error) {
+	if subject == "" {
+		return Identity{}, fmt.Errorf("%w: no subject", ErrInvalidCredentials)
+	}
+
+	if role == "" {
+		role = RoleMember
+	}
+
+	if !role.Valid() {
+		return Identity{}, fmt.Errorf("%w: unknown role %q", ErrInvalidCredentials, role)
+	}
+
+	return Identity{Subject: subject, Role: role, Tenant: tenant}, nil
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/standoffvenus/future/internal/tenant"
+)
+
+type Action string
+
+const (
+	ActionCreateAppointment Action = "appointment:create"
+	ActionGetAppointment    Action = "appointment:get"
+	ActionListAppointments  Action = "appointment:list"
+	ActionUpdateAppointment Action = "appointment:update"
+	ActionCancelAppointment Action = "appointment:cancel"
+	ActionGetHistory        Action = "appointment:history"
+	ActionAccessTenant      Action = "tenant:access"
+
+	ActionCreateTrainer Action = "trainer:create"
+	ActionGetTrainer    Action = "trainer:get"
+	ActionListTrainers  Action = "trainer:list"
+	ActionUpdateTrainer Action = "trainer:update"
+	ActionDeleteTrainer Action = "trainer:delete"
+	ActionCreateMember  Action = "member:create"
+	ActionGetMember     Action = "member:get"
+	ActionListMembers   Action = "member:list"
+	ActionUpdateMember  Action = "member:update"
+	ActionDeleteMember  Action = "member:delete"
+
+	ActionCreateResource           Action = "resource:create"
+	ActionGetResource              Action = "resource:get"
+	ActionListResources            Action = "resource:list"
+	ActionUpdateResource           Action = "resource:update"
+	ActionDeleteResource           Action = "resource:delete"
+	ActionListResourceAppointments Action = "resource:list_appointments"
+)
+
+// Reasons a Denial may carry; these are part of the API and must not change.
+const (
+	ReasonNotOwnBooking  = "not_own_booking"
+	ReasonNotOwnCalendar = "not_own_calendar"
+	ReasonUnknownRole    = "unknown_role"
+	ReasonOtherTenant    = "other_tenant"
+	ReasonAdminOnly      = "admin_only"
+)
+
+// Resource describes the appointments an action touches. For listings,
+// an empty UserID means every user's appointments. For the directory, the
+// trainer's, member's or bookable resource's ID is set.
+type Resource struct {
+	TrainerID  string
+	UserID     string
+	ResourceID string
+}
+
+type Denial struct {
+	Action Action
+	Role   Role
+	Reason string
+}
+
+func (d *Denial) Error() string {
+	return fmt.Sprintf("%s may not %s: %s", d.Role, d.Action, d.Reason)
+}
+
+// Authorize returns a *Denial if the caller may not perform the action on
+// the resource. Members manage their own bookings, trainers manage their own
+// calendar and admins may do anything. Anyone may look trainers and
+// resources up, to book with them, but only admins change the directory or
+// list its members. A resource's calendar is open to trainers, who share
+// rooms and equipment, but members only see their own bookings in it. A nil
+// identity means authentication is disabled, so everything is allowed.
+func Authorize(identity *Identity, action Action, resource Resource) error {
+	if identity == nil {
+		return nil
+	}
+
+	deny := func(reason string) error {
+		return &Denial{Action: action, Role: identity.Role, Reason: reason}
+	}
+
+	switch action {
+	case ActionGetTrainer, ActionListTrainers, ActionGetResource, ActionListResources:
+		return nil
+	case ActionCreateTrainer, ActionUpdateTrainer, ActionDeleteTrainer,
+		ActionCreateMember, ActionUpdateMember, ActionDeleteMember, ActionListMembers,
+		ActionCreateResource, ActionUpdateResource, ActionDeleteResource:
+		if identity.Role != RoleAdmin {
+			return deny(ReasonAdminOnly)
+		}
+
+		return nil
+	case ActionListResourceAppointments:
+		if identity.Role == RoleTrainer {
+			return nil
+		}
+	}
+
+	switch identity.Role {
+	case RoleAdmin:
+		return nil
+	case RoleTrainer:
+		if resource.TrainerID != identity.Subject {
+			return deny(ReasonNotOwnCalendar)
+		}
+
+		return nil
+	case RoleMember:
+		if resource.UserID != identity.Subject {
+			return deny(ReasonNotOwnBooking)
+		}
+
+		return nil
+	}
+
+	return deny(ReasonUnknownRole)
+}
+
+// AuthorizeTenant returns a *Denial if the caller belongs to a tenant other
+// than the one the request is for. Only admins without a tenant may act
+// within any; members and trainers without one, whose credentials predate
+// tenants, belong to the default tenant.
+func AuthorizeTenant(identity *Identity, tenantID string) error {
+	if identity == nil {
+		return nil
+	}
+
+	own := identity.Tenant
+	if own == "" {
+		if identity.Role == RoleAdmin {
+			return nil
+		}
+
+		own = tenant.Default
+	}
+
+	if own == tenantID {
+		return nil
+	}
+
+	return &Denial{Action: ActionAccessTenant, Role: identity.Role, Reason: ReasonOtherTenant}
+}
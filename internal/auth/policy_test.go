@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAuthorizeTenant(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity *Identity
+		tenant   string
+		allowed  bool
+	}{
+		{name: "authentication disabled", identity: nil, tenant: "downtown", allowed: true},
+		{name: "member of the tenant", identity: &Identity{Subject: "u", Role: RoleMember, Tenant: "downtown"}, tenant: "downtown", allowed: true},
+		{name: "member of another tenant", identity: &Identity{Subject: "u", Role: RoleMember, Tenant: "uptown"}, tenant: "downtown"},
+		{name: "admin of another tenant", identity: &Identity{Subject: "a", Role: RoleAdmin, Tenant: "uptown"}, tenant: "downtown"},
+		{name: "admin without a tenant", identity: &Identity{Subject: "a", Role: RoleAdmin}, tenant: "downtown", allowed: true},
+		{name: "member without a tenant", identity: &Identity{Subject: "u", Role: RoleMember}, tenant: "downtown"},
+		{name: "trainer without a tenant", identity: &Identity{Subject: "t", Role: RoleTrainer}, tenant: "downtown"},
+		{name: "member without a tenant, in the default tenant", identity: &Identity{Subject: "u", Role: RoleMember}, tenant: "default", allowed: true},
+	}
+
+	for _, test := range tests {
+		err := AuthorizeTenant(test.identity, test.tenant)
+
+		var denial *Denial
+		switch {
+		case test.allowed && err != nil:
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		case !test.allowed && !errors.As(err, &denial):
+			t.Errorf("%s: expected a denial, got %v", test.name, err)
+		case !test.allowed && denial.Reason != ReasonOtherTenant:
+			t.Errorf("%s: expected reason %s, got %s", test.name, ReasonOtherTenant, denial.Reason)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+)
+
+// Tolerated clock skew between us and the token issuer.
+const leeway = 30 * time.Second
+
+// TokenVerifier checks JWTs signed with HS256, RS256 or both. A token is only
+// accepted for an algorithm whose key is configured, so an RS256 public key
+// can never be used as an HMAC secret.
+type TokenVerifier struct {
+	HS256Secret    []byte
+	RS256PublicKey *rsa.PublicKey
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+}
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	Tenant    string `json:"tenant"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+}
+
+func LoadHS256Secret(file string) ([]byte, error) {
+	fileBytes, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := bytes.TrimSpace(fileBytes)
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("auth: HS256 secret in %q is shorter than 32 bytes", file)
+	}
+
+	return secret, nil
+}
+
+// LoadRS256PublicKey reads a PEM encoded PKIX or PKCS #1 RSA public key.
+func LoadRS256PublicKey(file string) (*rsa.PublicKey, error) {
+	fileBytes, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(fileBytes)
+	if block == nil {
+		return nil, fmt.Errorf("auth: no PEM data in %q", file)
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("auth: could not parse public key in %q: %w", file, err)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("auth: public key in %q is not an RSA key", file)
+	}
+
+	return rsaKey, nil
+}
+
+func (v *TokenVerifier) Verify(token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: malformed signature", ErrInvalidCredentials)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := v.verifySignature(header.Algorithm, signed, signature); err != nil {
+		return Identity{}, err
+	}
+
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, err
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(leeway)) {
+		return Identity{}, fmt.Errorf("%w: token expired", ErrInvalidCredentials)
+	}
+
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-leeway)) {
+		return Identity{}, fmt.Errorf("%w: token not yet valid", ErrInvalidCredentials)
+	}
+
+	return newIdentity(claims.Subject, claims.Role, claims.Tenant)
+}
+
+func (v *TokenVerifier) verifySignature(algorithm string, signed, signature []byte) error {
+	switch {
+	case algorithm == AlgorithmHS256 && len(v.HS256Secret) > 0:
+		mac := hmac.New(sha256.New, v.HS256Secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidCredentials)
+		}
+
+		return nil
+	case algorithm == AlgorithmRS256 && v.RS256PublicKey != nil:
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(v.RS256PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidCredentials)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidCredentials, algorithm)
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+
+	if err := jsoniter.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+
+	return nil
+}
@@ -0,0 +1,9 @@
+package build
+
+// Set when building, for example:
+//
+//	go build -ldflags "-X github.com/standoffvenus/future/internal/build.Version=v1.2.0"
+var (
+	Version = "dev"
+	Commit  = "unknown"
+)
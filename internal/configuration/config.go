@@ -0,0 +1,607 @@
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/standoffvenus/future/internal/appointment"
+	"github.com/standoffvenus/future/internal/tenant"
+	"gopkg.in/yaml.v2"
+)
+
+var ErrInvalid = errors.New("invalid configuration")
+
+// Config is everything the server can be configured with besides
+// credentials and tracing. Durations are written like "30m" or "24h".
+type Config struct {
+	Database     Database     `yaml:"database"`
+	Server       Server       `yaml:"server"`
+	Appointments Appointments `yaml:"appointments"`
+	Idempotency  Idempotency  `yaml:"idempotency"`
+	Tenancy      Tenancy      `yaml:"tenancy"`
+	Outbox       Outbox       `yaml:"outbox"`
+}
+
+type Database struct {
+	File        string        `yaml:"file"`
+	Table       string        `yaml:"table"`
+	BusyTimeout time.Duration `yaml:"busy_timeout"`
+}
+
+type Server struct {
+	Port               int           `yaml:"port"`
+	MaxBodyBytes       int64         `yaml:"max_body_bytes"`
+	DrainDelay         time.Duration `yaml:"drain_delay"`
+	DrainTimeout       time.Duration `yaml:"drain_timeout"`
+	HealthCheckTimeout time.Duration `yaml:"health_check_timeout"`
+}
+
+type Appointments struct {
+	Length         time.Duration `yaml:"length"`
+	MaxRangeLength time.Duration `yaml:"max_range_length"`
+	BusinessHours  Hours         `yaml:"business_hours"`
+}
+
+// Hours are whole hours on a 24-hour clock in an IANA time zone, e.g.
+// opening at 8 and closing at 17 in America/Los_Angeles.
+type Hours struct {
+	Location string `yaml:"location"`
+	Opens    int    `yaml:"opens"`
+	Closes   int    `yaml:"closes"`
+}
+
+// UnmarshalYAML refuses hours giving only one of opens and closes, since the
+// other would be left at midnight or at a default meant for different hours.
+func (h *Hours) UnmarshalYAML(unmarshal func(any) error) error {
+	var raw hoursYAML
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	if (raw.Opens == nil) != (raw.Closes == nil) {
+		return errors.New("business_hours must give both opens and closes, or neither")
+	}
+
+	if raw.Location != nil {
+		h.Location = *raw.Location
+	}
+
+	if raw.Opens != nil {
+		h.Opens, h.Closes = *raw.Opens, *raw.Closes
+	}
+
+	return nil
+}
+
+// Hours as written, so UnmarshalYAML can tell a missing hour from midnight.
+type hoursYAML struct {
+	Location *string `yaml:"location"`
+	Opens    *int    `yaml:"opens"`
+	Closes   *int    `yaml:"closes"`
+}
+
+// Idempotency keys are held by their request for Lease, after which a retry
+// may take over, and keep its response for KeyTTL.
+type Idempotency struct {
+	KeyTTL time.Duration `yaml:"key_ttl"`
+	Lease  time.Duration `yaml:"lease"`
+}
+
+// Tenancy lets one server book appointments for several gyms. Without any
+// Tenants, every request is for tenant.Default and follows Appointments.
+type Tenancy struct {
+	// Domain, if set, lets requests name their tenant with a subdomain of it.
+	Domain string `yaml:"domain"`
+
+	// Tenants' appointments follow their own rules, taking anything they
+	// leave unset from Appointments.
+	Tenants map[string]Appointments `yaml:"tenants"`
+}
+
+// Outbox delivers appointment events to each sink configured: the log, a
+// file of JSON lines and a webhook. Without any, events are kept until one
+// is configured. A Retention of 0 keeps delivered events forever.
+type Outbox struct {
+	PollInterval   time.Duration `yaml:"poll_interval"`
+	BatchSize      int           `yaml:"batch_size"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	Retention      time.Duration `yaml:"retention"`
+	Log            bool          `yaml:"log"`
+	File           string        `yaml:"file"`
+	WebhookURL     string        `yaml:"webhook_url"`
+	WebhookTimeout time.Duration `yaml:"webhook_timeout"`
+}
+
+var (
+	// The table name is formatted into SQL, so it's held to a plain identifier.
+	identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// Tenant IDs may be used as subdomains.
+	tenantID = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+)
+
+func Default() Config {
+	return Config{
+		Database: Database{
+			File:        DatabaseFile,
+			Table:       Table,
+			BusyTimeout: BusyTimeout,
+		},
+		Server: Server{
+			Port:               Port,
+			MaxBodyBytes:       MaxBodyBytes,
+			DrainDelay:         DrainDelay,
+			DrainTimeout:       DrainTimeout,
+			HealthCheckTimeout: HealthCheckTimeout,
+		},
+		Appointments: Appointments{
+			Length:         LengthOfAppointment,
+			MaxRangeLength: MaxRangeLength,
+			BusinessHours: Hours{
+				Location: LocationPST.String(),
+				Opens:    BusinessHours.Start,
+				Closes:   BusinessHours.End + 1,
+			},
+		},
+		Idempotency: Idempotency{
+			KeyTTL: IdempotencyKeyTTL,
+			Lease:  IdempotencyLease,
+		},
+		Outbox: Outbox{
+			PollInterval:   OutboxPollInterval,
+			BatchSize:      OutboxBatchSize,
+			MaxBackoff:     OutboxMaxBackoff,
+			MaxAttempts:    OutboxMaxAttempts,
+			Retention:      OutboxRetention,
+			WebhookTimeout: WebhookTimeout,
+		},
+	}
+}
+
+// Load starts from the defaults, applies the YAML file, if any, and then
+// any APPT_* environment variables. The result isn't validated, since
+// callers may still override it; call Validate once they're done.
+func Load(file string) (Config, error) {
+	config := Default()
+	if strings.TrimSpace(file) != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return Config{}, err
+		}
+
+		// Strict, so a misspelt key fails instead of silently being ignored.
+		if err := yaml.UnmarshalStrict(b, &config); err != nil {
+			return Config{}, fmt.Errorf("%w: %s: %v", ErrInvalid, file, err)
+		}
+	}
+
+	for _, setting := range settings {
+		value, ok := os.LookupEnv(setting.Env)
+		if !ok {
+			continue
+		}
+
+		if err := setting.Set(&config, value); err != nil {
+			return Config{}, fmt.Errorf("%w: %s: %v", ErrInvalid, setting.Env, err)
+		}
+	}
+
+	for id, appointments := range config.Tenancy.Tenants {
+		config.Tenancy.Tenants[id] = appointments.inherit(config.Appointments)
+	}
+
+	return config, nil
+}
+
+// Fills in whatever a tenant leaves unset from defaults. Business hours are
+// taken whole unless the tenant gives its own, which UnmarshalYAML makes sure
+// has both an opening and a closing hour.
+func (a Appointments) inherit(defaults Appointments) Appointments {
+	if a.Length == 0 {
+		a.Length = defaults.Length
+	}
+
+	if a.MaxRangeLength == 0 {
+		a.MaxRangeLength = defaults.MaxRangeLength
+	}
+
+	if a.BusinessHours.Location == "" {
+		a.BusinessHours.Location = defaults.BusinessHours.Location
+	}
+
+	if a.BusinessHours.Opens == 0 && a.BusinessHours.Closes == 0 {
+		a.BusinessHours.Opens = defaults.BusinessHours.Opens
+		a.BusinessHours.Closes = defaults.BusinessHours.Closes
+	}
+
+	return a
+}
+
+// setting is one configurable value, by its key in the file and its
+// environment variable. Only Reloadable settings take effect without a
+// restart.
+type setting struct {
+	Key        string
+	Env        string
+	Reloadable bool
+	Get        func(Config) string
+	Set        func(*Config, string) error
+}
+
+var settings = []setting{
+	{
+		Key: "database.file",
+		Env: "APPT_DATABASE_FILE",
+		Get: func(c Config) string { return c.Database.File },
+		Set: func(c *Config, s string) error { c.Database.File = s; return nil },
+	},
+	{
+		Key: "database.table",
+		Env: "APPT_DATABASE_TABLE",
+		Get: func(c Config) string { return c.Database.Table },
+		Set: func(c *Config, s string) error { c.Database.Table = s; return nil },
+	},
+	{
+		Key: "database.busy_timeout",
+		Env: "APPT_DATABASE_BUSY_TIMEOUT",
+		Get: func(c Config) string { return c.Database.BusyTimeout.String() },
+		Set: func(c *Config, s string) error { return parseDuration(s, &c.Database.BusyTimeout) },
+	},
+	{
+		Key: "server.port",
+		Env: "APPT_SERVER_PORT",
+		Get: func(c Config) string { return strconv.Itoa(c.Server.Port) },
+		Set: func(c *Config, s string) error { return parseInt(s, &c.Server.Port) },
+	},
+	{
+		Key: "server.max_body_bytes",
+		Env: "APPT_SERVER_MAX_BODY_BYTES",
+		Get: func(c Config) string { return strconv.FormatInt(c.Server.MaxBodyBytes, 10) },
+		Set: func(c *Config, s string) (err error) {
+			c.Server.MaxBodyBytes, err = strconv.ParseInt(s, 10, 64)
+			return err
+		},
+	},
+	{
+		Key: "server.drain_delay",
+		Env: "APPT_SERVER_DRAIN_DELAY",
+		Get: func(c Config) string { return c.Server.DrainDelay.String() },
+		Set: func(c *Config, s string) error { return parseDuration(s, &c.Server.DrainDelay) },
+	},
+	{
+		Key: "server.drain_timeout",
+		Env: "APPT_SERVER_DRAIN_TIMEOUT",
+		Get: func(c Config) string { return c.Server.DrainTimeout.String() },
+		Set: func(c *Config, s string) error { return parseDuration(s, &c.Server.DrainTimeout) },
+	},
+	{
+		Key: "server.health_check_timeout",
+		Env: "APPT_SERVER_HEALTH_CHECK_TIMEOUT",
+		Get: func(c Config) string { return c.Server.HealthCheckTimeout.String() },
+		Set: func(c *Config, s string) error { return parseDuration(s, &c.Server.HealthCheckTimeout) },
+	},
+	{
+		Key:        "appointments.length",
+		Env:        "APPT_APPOINTMENTS_LENGTH",
+		Reloadable: true,
+		Get:        func(c Config) string { return c.Appointments.Length.String() },
+		Set:        func(c *Config, s string) error { return parseDuration(s, &c.Appointments.Length) },
+	},
+	{
+		Key:        "appointments.max_range_length",
+		Env:        "APPT_APPOINTMENTS_MAX_RANGE_LENGTH",
+		Reloadable: true,
+		Get:        func(c Config) string { return c.Appointments.MaxRangeLength.String() },
+		Set:        func(c *Config, s string) error { return parseDuration(s, &c.Appointments.MaxRangeLength) },
+	},
+	{
+		Key:        "appointments.business_hours.location",
+		Env:        "APPT_BUSINESS_HOURS_LOCATION",
+		Reloadable: true,
+		Get:        func(c Config) string { return c.Appointments.BusinessHours.Location },
+		Set:        func(c *Config, s string) error { c.Appointments.BusinessHours.Location = s; return nil },
+	},
+	{
+		Key:        "appointments.business_hours.opens",
+		Env:        "APPT_BUSINESS_HOURS_OPENS",
+		Reloadable: true,
+		Get:        func(c Config) string { return strconv.Itoa(c.Appointments.BusinessHours.Opens) },
+		Set:        func(c *Config, s string) error { return parseInt(s, &c.Appointments.BusinessHours.Opens) },
+	},
+	{
+		Key:        "appointments.business_hours.closes",
+		Env:        "APPT_BUSINESS_HOURS_CLOSES",
+		Reloadable: true,
+		Get:        func(c Config) string { return strconv.Itoa(c.Appointments.BusinessHours.Closes) },
+		Set:        func(c *Config, s string) error { return parseInt(s, &c.Appointments.BusinessHours.Closes) },
+	},
+	{
+		Key: "idempotency.key_ttl",
+		Env: "APPT_IDEMPOTENCY_KEY_TTL",
+		Get: func(c Config) string { return c.Idempotency.KeyTTL.String() },
+		Set: func(c *Config, s string) error { return parseDuration(s, &c.Idempotency.KeyTTL) },
+	},
+	{
+		Key: "idempotency.lease",
+		Env: "APPT_IDEMPOTENCY_LEASE",
+		Get: func(c Config) string { return c.Idempotency.Lease.String() },
+		Set: func(c *Config, s string) error { return parseDuration(s, &c.Idempotency.Lease) },
+	},
+	{
+		Key: "tenancy.domain",
+		Env: "APPT_TENANCY_DOMAIN",
+		Get: func(c Config) string { return c.Tenancy.Domain },
+		Set: func(c *Config, s string) error { c.Tenancy.Domain = s; return nil },
+	},
+	{
+		Key: "outbox.poll_interval",
+		Env: "APPT_OUTBOX_POLL_INTERVAL",
+		Get: func(c Config) string { return c.Outbox.PollInterval.String() },
+		Set: func(c *Config, s string) error { return parseDuration(s, &c.Outbox.PollInterval) },
+	},
+	{
+		Key: "outbox.batch_size",
+		Env: "APPT_OUTBOX_BATCH_SIZE",
+		Get: func(c Config) string { return strconv.Itoa(c.Outbox.BatchSize) },
+		Set: func(c *Config, s string) error { return parseInt(s, &c.Outbox.BatchSize) },
+	},
+	{
+		Key: "outbox.max_backoff",
+		Env: "APPT_OUTBOX_MAX_BACKOFF",
+		Get: func(c Config) string { return c.Outbox.MaxBackoff.String() },
+		Set: func(c *Config, s string) error { return parseDuration(s, &c.Outbox.MaxBackoff) },
+	},
+	{
+		Key: "outbox.max_attempts",
+		Env: "APPT_OUTBOX_MAX_ATTEMPTS",
+		Get: func(c Config) string { return strconv.Itoa(c.Outbox.MaxAttempts) },
+		Set: func(c *Config, s string) error { return parseInt(s, &c.Outbox.MaxAttempts) },
+	},
+	{
+		Key: "outbox.retention",
+		Env: "APPT_OUTBOX_RETENTION",
+		Get: func(c Config) string { return c.Outbox.Retention.String() },
+		Set: func(c *Config, s string) error { return parseDuration(s, &c.Outbox.Retention) },
+	},
+	{
+		Key: "outbox.log",
+		Env: "APPT_OUTBOX_LOG",
+		Get: func(c Config) string { return strconv.FormatBool(c.Outbox.Log) },
+		Set: func(c *Config, s string) (err error) {
+			c.Outbox.Log, err = strconv.ParseBool(s)
+			return err
+		},
+	},
+	{
+		Key: "outbox.file",
+		Env: "APPT_OUTBOX_FILE",
+		Get: func(c Config) string { return c.Outbox.File },
+		Set: func(c *Config, s string) error { c.Outbox.File = s; return nil },
+	},
+	{
+		Key: "outbox.webhook_url",
+		Env: "APPT_OUTBOX_WEBHOOK_URL",
+		Get: func(c Config) string { return c.Outbox.WebhookURL },
+		Set: func(c *Config, s string) error { c.Outbox.WebhookURL = s; return nil },
+	},
+	{
+		Key: "outbox.webhook_timeout",
+		Env: "APPT_OUTBOX_WEBHOOK_TIMEOUT",
+		Get: func(c Config) string { return c.Outbox.WebhookTimeout.String() },
+		Set: func(c *Config, s string) error { return parseDuration(s, &c.Outbox.WebhookTimeout) },
+	},
+}
+
+func parseInt(s string, dst *int) (err error) {
+	*dst, err = strconv.Atoi(s)
+	return err
+}
+
+func parseDuration(s string, dst *time.Duration) (err error) {
+	*dst, err = time.ParseDuration(s)
+	return err
+}
+
+// Change is a setting that differs between two configs.
+type Change struct {
+	Key        string
+	Old        string
+	New        string
+	Reloadable bool
+}
+
+// Diff lists the settings changed from old to new, in the order they're
+// written in the file, followed by those of each tenant. Tenants are
+// reloadable, so they may be added and removed too.
+func Diff(old, new Config) []Change {
+	var changes []Change
+	for _, setting := range settings {
+		if o, n := setting.Get(old), setting.Get(new); o != n {
+			changes = append(changes, Change{Key: setting.Key, Old: o, New: n, Reloadable: setting.Reloadable})
+		}
+	}
+
+	ids := make([]string, 0, len(old.Tenancy.Tenants)+len(new.Tenancy.Tenants))
+	for id := range old.Tenancy.Tenants {
+		ids = append(ids, id)
+	}
+	for id := range new.Tenancy.Tenants {
+		if _, ok := old.Tenancy.Tenants[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		key := "tenancy.tenants." + id
+		before, hadOld := old.Tenancy.Tenants[id]
+		after, hasNew := new.Tenancy.Tenants[id]
+		switch {
+		case !hadOld:
+			changes = append(changes, Change{Key: key, New: "added", Reloadable: true})
+			continue
+		case !hasNew:
+			changes = append(changes, Change{Key: key, Old: "removed", Reloadable: true})
+			continue
+		}
+
+		// Tenants are configured like the top level appointments, so their
+		// settings are compared the same way.
+		for _, setting := range settings {
+			if !strings.HasPrefix(setting.Key, "appointments.") {
+				continue
+			}
+
+			if o, n := setting.Get(Config{Appointments: before}), setting.Get(Config{Appointments: after}); o != n {
+				changes = append(changes, Change{
+					Key:        key + strings.TrimPrefix(setting.Key, "appointments"),
+					Old:        o,
+					New:        n,
+					Reloadable: true,
+				})
+			}
+		}
+	}
+
+	return changes
+}
+
+// Validate reports every invalid setting at once, so they can all be fixed
+// before the next attempt.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(strings.TrimSpace(c.Database.File) != "", "database.file must be set")
+	check(identifier.MatchString(c.Database.Table), "database.table %q must be letters, digits and underscores", c.Database.Table)
+	check(c.Database.BusyTimeout >= 0, "database.busy_timeout must not be negative")
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port %d must be between 1 and 65535", c.Server.Port)
+	check(c.Server.MaxBodyBytes > 0, "server.max_body_bytes must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay must not be negative")
+	check(c.Server.DrainTimeout >= 0, "server.drain_timeout must not be negative")
+	check(c.Server.HealthCheckTimeout > 0, "server.health_check_timeout must be positive")
+	check(c.Idempotency.KeyTTL > 0, "idempotency.key_ttl must be positive")
+	check(c.Idempotency.Lease > 0 && c.Idempotency.Lease <= c.Idempotency.KeyTTL,
+		"idempotency.lease %s must be positive and at most idempotency.key_ttl", c.Idempotency.Lease)
+	check(c.Outbox.PollInterval > 0, "outbox.poll_interval must be positive")
+	check(c.Outbox.BatchSize > 0, "outbox.batch_size must be positive")
+	check(c.Outbox.MaxBackoff > 0, "outbox.max_backoff must be positive")
+	check(c.Outbox.MaxAttempts > 0, "outbox.max_attempts must be positive")
+	check(c.Outbox.Retention >= 0, "outbox.retention must not be negative")
+	check(c.Outbox.WebhookTimeout > 0, "outbox.webhook_timeout must be positive")
+
+	if c.Outbox.WebhookURL != "" {
+		u, err := url.Parse(c.Outbox.WebhookURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"outbox.webhook_url %q must be an http or https URL", c.Outbox.WebhookURL)
+	}
+	c.Appointments.validate("appointments", check)
+
+	ids := make([]string, 0, len(c.Tenancy.Tenants))
+	for id := range c.Tenancy.Tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		check(tenantID.MatchString(id), "tenancy.tenants: %q must be lowercase letters, digits and dashes", id)
+		c.Tenancy.Tenants[id].validate("tenancy.tenants."+id, check)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalid, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func (a Appointments) validate(prefix string, check func(bool, string, ...any)) {
+	check(a.Length > 0 && a.Length <= appointment.MaxLengthOfAppointment,
+		"%s.length must be positive and at most %s", prefix, appointment.MaxLengthOfAppointment)
+	check(a.MaxRangeLength >= 0, "%s.max_range_length must not be negative", prefix)
+
+	hours := a.BusinessHours
+	check(hours.Opens >= 0 && hours.Opens < hours.Closes && hours.Closes <= 24,
+		"%s.business_hours must open before they close, between 0 and 24 (got %d to %d)", prefix, hours.Opens, hours.Closes)
+
+	_, err := time.LoadLocation(hours.Location)
+	check(err == nil, "%s.business_hours.location %q is not a time zone", prefix, hours.Location)
+}
+
+// IdempotencyTable is created by the appointment schema's migrations
+// alongside the appointments table.
+func (d Database) IdempotencyTable() string {
+	return d.Table + "_idempotency_keys"
+}
+
+// TrainersTable is created by the appointment schema's migrations alongside
+// the appointments table.
+func (d Database) TrainersTable() string {
+	return d.Table + "_trainers"
+}
+
+// MembersTable is created by the appointment schema's migrations alongside
+// the appointments table.
+func (d Database) MembersTable() string {
+	return d.Table + "_members"
+}
+
+// ResourcesTable is created by the appointment schema's migrations alongside
+// the appointments table.
+func (d Database) ResourcesTable() string {
+	return d.Table + "_resources"
+}
+
+// OutboxTable is created by the appointment schema's migrations alongside
+// the appointments table.
+func (d Database) OutboxTable() string {
+	return d.Table + "_outbox"
+}
+
+// Rules are the rules each tenant's appointments are booked by. The config
+// must have been validated.
+func (c Config) Rules() map[string]appointment.Rules {
+	if len(c.Tenancy.Tenants) == 0 {
+		return map[string]appointment.Rules{tenant.Default: c.Appointments.Rules()}
+	}
+
+	rules := make(map[string]appointment.Rules, len(c.Tenancy.Tenants))
+	for id, appointments := range c.Tenancy.Tenants {
+		rules[id] = appointments.Rules()
+	}
+
+	return rules
+}
+
+// Rules are the settings appointments are booked by. The config must have
+// been validated.
+func (a Appointments) Rules() appointment.Rules {
+	loc, err := time.LoadLocation(a.BusinessHours.Location)
+	if err != nil {
+		panic(fmt.Sprintf("configuration: could not parse time location %q", a.BusinessHours.Location))
+	}
+
+	// The service compares the hour appointments end in, so closing at 17
+	// means ending in the 16th hour at the latest.
+	return appointment.Rules{
+		LengthOfAppointment: a.Length,
+		MaxRangeLength:      a.MaxRangeLength,
+		BusinessHours: appointment.BusinessHours{
+			Location: loc,
+			Start:    a.BusinessHours.Opens,
+			End:      a.BusinessHours.Closes - 1,
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package configuration_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/standoffvenus/future/internal/configuration"
+)
+
+func TestLoadTenantBusinessHours(t *testing.T) {
+	defaults := configuration.Default().Appointments.BusinessHours
+
+	tests := []struct {
+		name     string
+		hours    string
+		expected configuration.Hours
+		err      error
+	}{
+		{
+			name:     "inherited",
+			hours:    "{location: America/New_York}",
+			expected: configuration.Hours{Location: "America/New_York", Opens: defaults.Opens, Closes: defaults.Closes},
+		},
+		{
+			name:     "own",
+			hours:    "{opens: 0, closes: 12}",
+			expected: configuration.Hours{Location: defaults.Location, Opens: 0, Closes: 12},
+		},
+		{
+			name:  "only closes",
+			hours: "{closes: 12}",
+			err:   configuration.ErrInvalid,
+		},
+		{
+			name:  "only opens",
+			hours: "{opens: 6}",
+			err:   configuration.ErrInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.yaml")
+			yaml := "tenancy:\n  tenants:\n    downtown:\n      business_hours: " + test.hours + "\n"
+			if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := configuration.Load(file)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if hours := config.Tenancy.Tenants["downtown"].BusinessHours; hours != test.expected {
+				t.Fatalf("expected %+v, got %+v", test.expected, hours)
+			}
+		})
+	}
+}
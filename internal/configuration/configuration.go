@@ -7,9 +7,27 @@ import (
 	"github.com/standoffvenus/future/internal/appointment"
 )
 
+// Defaults, used for anything the configuration file and environment leave
+// unset.
 const (
+	DatabaseFile        string        = "db.sqlite3"
 	Table               string        = "appointments"
+	BusyTimeout         time.Duration = 5 * time.Second
+	Port                int           = 8080
+	MaxBodyBytes        int64         = 64 << 10
+	DrainDelay          time.Duration = 0
+	DrainTimeout        time.Duration = 8 * time.Second
+	HealthCheckTimeout  time.Duration = 2 * time.Second
 	LengthOfAppointment time.Duration = 30 * time.Minute
+	MaxRangeLength      time.Duration = 92 * 24 * time.Hour
+	IdempotencyKeyTTL   time.Duration = 24 * time.Hour
+	IdempotencyLease    time.Duration = time.Minute
+	OutboxPollInterval  time.Duration = time.Second
+	OutboxBatchSize     int           = 100
+	OutboxMaxBackoff    time.Duration = 5 * time.Minute
+	OutboxMaxAttempts   int           = 20
+	OutboxRetention     time.Duration = 7 * 24 * time.Hour
+	WebhookTimeout      time.Duration = 10 * time.Second
 )
 
 var (
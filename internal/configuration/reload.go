@@ -0,0 +1,158 @@
+package configuration
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Reloader re-reads the configuration when its file changes, or whenever it's
+// told to. Configs that fail to load or validate are logged and ignored, so
+// the server keeps running on the last good one.
+type Reloader struct {
+	// File is the configuration file given at startup; without one, only
+	// requested reloads happen, picking up nothing but the defaults and
+	// environment.
+	File string
+
+	// Interval is how often File is checked for changes.
+	Interval time.Duration
+
+	// Override, if set, applies what the command line overrides.
+	Override func(*Config)
+
+	// Apply puts a config's reloadable settings into effect.
+	Apply func(Config)
+
+	current Config
+	modTime time.Time
+	size    int64
+	statErr bool
+}
+
+// Run reloads on every value received from requests, and when File changes,
+// until ctx is done. current is the config the server was started with.
+func (r *Reloader) Run(ctx context.Context, current Config, requests <-chan os.Signal) {
+	r.current = current
+	r.changed()
+
+	var poll <-chan time.Time
+	if r.File != "" {
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-requests:
+			log.
+				Info().
+				Fields(map[string]any{
+					"signal": sig.String(),
+				}).
+				Msg("Reloading configuration.")
+
+			r.reload()
+		case <-poll:
+			if r.changed() {
+				log.
+					Info().
+					Fields(map[string]any{
+						"file": r.File,
+					}).
+					Msg("Configuration file changed; reloading configuration.")
+
+				r.reload()
+			}
+		}
+	}
+}
+
+// changed records the file's modification time and size, reporting whether
+// either differs from when it was last looked at. Editors and Kubernetes
+// replace files rather than writing to them, so Stat follows symlinks to
+// whatever the file currently is.
+func (r *Reloader) changed() bool {
+	if r.File == "" {
+		return false
+	}
+
+	info, err := os.Stat(r.File)
+	if err != nil {
+		// Logged once, rather than on every poll, until the file's back.
+		if !r.statErr {
+			log.
+				Warn().
+				Err(err).
+				Fields(map[string]any{
+					"file": r.File,
+				}).
+				Msg("Could not check configuration file for changes.")
+		}
+		r.statErr = true
+
+		return false
+	}
+	r.statErr = false
+
+	changed := !info.ModTime().Equal(r.modTime) || info.Size() != r.size
+	r.modTime, r.size = info.ModTime(), info.Size()
+
+	return changed
+}
+
+func (r *Reloader) reload() {
+	next, err := Load(r.File)
+	if err == nil {
+		if r.Override != nil {
+			r.Override(&next)
+		}
+		err = next.Validate()
+	}
+
+	if err != nil {
+		log.
+			Error().
+			Err(err).
+			Msg("Could not reload configuration; keeping the current configuration.")
+
+		return
+	}
+
+	changes := Diff(r.current, next)
+	if len(changes) == 0 {
+		log.Info().Msg("Configuration unchanged.")
+		return
+	}
+
+	for _, change := range changes {
+		event := log.Info()
+		message := "Configuration changed."
+		if !change.Reloadable {
+			event = log.Warn()
+			message = "Configuration changed; restart the server for it to take effect."
+		}
+
+		event.
+			Fields(map[string]any{
+				"setting": change.Key,
+				"old":     change.Old,
+				"new":     change.New,
+			}).
+			Msg(message)
+	}
+
+	r.Apply(next)
+
+	// Only the appointment rules and tenants are reloadable; the rest stays
+	// as it was started with, so its changes keep being reported until a
+	// restart.
+	r.current.Appointments = next.Appointments
+	r.current.Tenancy.Tenants = next.Tenancy.Tenants
+}
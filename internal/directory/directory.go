@@ -0,0 +1,249 @@
+package directory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/standoffvenus/future/internal/application"
+	"github.com/standoffvenus/future/internal/tenant"
+	"github.com/standoffvenus/future/internal/tracing"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/standoffvenus/future/internal/directory")
+
+var (
+	ErrNotFound = errors.New("not found in the directory")
+	ErrIDTaken  = errors.New("ID already in the directory")
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// Entry is a trainer, member, or bookable resource such as a room or piece of
+// equipment, that appointments can be booked for. A resource's TimeZone is
+// where it is. Inactive entries are kept for the appointments they already
+// have, but can't be booked for again.
+type Entry struct {
+	ID          string
+	DisplayName string
+	TimeZone    string
+	Active      bool
+}
+
+// Page is up to Limit entries, ordered by ID, after the ID After.
+type Page struct {
+	Limit int
+	After string
+}
+
+type Listing struct {
+	Entries []Entry
+
+	// Next is the ID to list the following page after, if there is one.
+	Next string
+}
+
+type Repository interface {
+	Get(context.Context, string) (Entry, error)
+	List(context.Context, Page) (Listing, error)
+	Create(context.Context, Entry) (Entry, error)
+	Update(context.Context, Entry) (Entry, error)
+	Delete(context.Context, string) error
+}
+
+// SQLRepository keeps one kind of entry, trainers, members or resources, in
+// a table created by the appointment schema's migrations. Like appointments,
+// entries belong to the tenant in the context they're created with.
+type SQLRepository struct {
+	Database *sql.DB
+	Table    string
+}
+
+var _ Repository = new(SQLRepository)
+
+func (r *SQLRepository) Get(ctx context.Context, id string) (entry Entry, err error) {
+	ctx, span := tracer.Start(ctx, "SQLRepository.Get")
+	defer func() { tracing.End(span, err) }()
+
+	const Query = `
+SELECT id, display_name, time_zone, active
+  FROM %s
+ WHERE tenant_id = :tenant_id
+   AND id = :id
+`
+
+	formattedQuery := fmt.Sprintf(Query, r.Table)
+	err = tracing.Query(ctx, tracer, r.Table, "SELECT", formattedQuery, func(ctx context.Context) error {
+		row := r.Database.QueryRowContext(ctx, formattedQuery,
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("id", id))
+
+		return row.Scan(&entry.ID, &entry.DisplayName, &entry.TimeZone, &entry.Active)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	return entry, err
+}
+
+func (r *SQLRepository) List(ctx context.Context, page Page) (listing Listing, err error) {
+	ctx, span := tracer.Start(ctx, "SQLRepository.List")
+	defer func() { tracing.End(span, err) }()
+
+	// One more entry than the limit is selected, to tell whether another
+	// page follows.
+	const Query = `
+SELECT id, display_name, time_zone, active
+  FROM %s
+ WHERE tenant_id = :tenant_id
+   AND id > :after
+ ORDER BY id
+ LIMIT :limit
+`
+
+	formattedQuery := fmt.Sprintf(Query, r.Table)
+	err = tracing.Query(ctx, tracer, r.Table, "SELECT", formattedQuery, func(ctx context.Context) error {
+		rows, err := r.Database.QueryContext(ctx, formattedQuery,
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("after", page.After),
+			sql.Named("limit", page.Limit+1))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		listing.Entries = make([]Entry, 0, page.Limit)
+		for rows.Next() {
+			var entry Entry
+			if err := rows.Scan(&entry.ID, &entry.DisplayName, &entry.TimeZone, &entry.Active); err != nil {
+				return err
+			}
+
+			listing.Entries = append(listing.Entries, entry)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return Listing{}, err
+	}
+
+	if len(listing.Entries) > page.Limit {
+		listing.Entries = listing.Entries[:page.Limit]
+		listing.Next = listing.Entries[page.Limit-1].ID
+	}
+
+	return listing, nil
+}
+
+func (r *SQLRepository) Create(ctx context.Context, entry Entry) (created Entry, err error) {
+	ctx, span := tracer.Start(ctx, "SQLRepository.Create")
+	defer func() { tracing.End(span, err) }()
+
+	const Insert = `
+INSERT INTO %s(tenant_id, id, display_name, time_zone, active)
+     VALUES (:tenant_id, :id, :display_name, :time_zone, :active)
+`
+
+	formattedInsert := fmt.Sprintf(Insert, r.Table)
+	err = tracing.Query(ctx, tracer, r.Table, "INSERT", formattedInsert, func(ctx context.Context) error {
+		_, err := r.Database.ExecContext(ctx, formattedInsert,
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("id", entry.ID),
+			sql.Named("display_name", entry.DisplayName),
+			sql.Named("time_zone", entry.TimeZone),
+			sql.Named("active", entry.Active))
+		return err
+	})
+	if application.IsSQLiteError(err, sqlite3.ErrConstraintPrimaryKey) {
+		return Entry{}, fmt.Errorf("%w: %s", ErrIDTaken, entry.ID)
+	} else if err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+// Update replaces everything about the entry but its ID.
+func (r *SQLRepository) Update(ctx context.Context, entry Entry) (updated Entry, err error) {
+	ctx, span := tracer.Start(ctx, "SQLRepository.Update")
+	defer func() { tracing.End(span, err) }()
+
+	const Update = `
+UPDATE %s
+   SET display_name = :display_name,
+       time_zone = :time_zone,
+       active = :active
+ WHERE tenant_id = :tenant_id
+   AND id = :id
+`
+
+	formattedUpdate := fmt.Sprintf(Update, r.Table)
+	err = tracing.Query(ctx, tracer, r.Table, "UPDATE", formattedUpdate, func(ctx context.Context) error {
+		return expectOne(r.Database.ExecContext(ctx, formattedUpdate,
+			sql.Named("display_name", entry.DisplayName),
+			sql.Named("time_zone", entry.TimeZone),
+			sql.Named("active", entry.Active),
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("id", entry.ID)))
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, entry.ID)
+	} else if err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+// Delete removes the entry. Its appointments are kept, but nothing more can
+// be booked for it.
+func (r *SQLRepository) Delete(ctx context.Context, id string) (err error) {
+	ctx, span := tracer.Start(ctx, "SQLRepository.Delete")
+	defer func() { tracing.End(span, err) }()
+
+	const Delete = `
+DELETE FROM %s
+ WHERE tenant_id = :tenant_id
+   AND id = :id
+`
+
+	formattedDelete := fmt.Sprintf(Delete, r.Table)
+	err = tracing.Query(ctx, tracer, r.Table, "DELETE", formattedDelete, func(ctx context.Context) error {
+		return expectOne(r.Database.ExecContext(ctx, formattedDelete,
+			sql.Named("tenant_id", tenant.FromContext(ctx)),
+			sql.Named("id", id)))
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	return err
+}
+
+// Runs fn, which executes a single statement, in its own span. Finding no
+// rows isn't an error worth marking a span with.
+// Reports sql.ErrNoRows if a statement changed nothing.
+func expectOne(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
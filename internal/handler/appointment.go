@@ -2,23 +2,70 @@ package handler
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/standoffvenus/future/internal/appointment"
+	"github.com/standoffvenus/future/internal/audit"
+	"github.com/standoffvenus/future/internal/auth"
 	"github.com/standoffvenus/future/internal/empty"
 )
 
 const (
-	PathParameterTrainerID = "trainer_id"
+	PathParameterID        = "id"
 	QueryParameterStart    = "starts_at"
 	QueryParameterEnd      = "ends_at"
+	QueryParameterLimit    = "limit"
+	QueryParameterCursor   = "cursor"
+	QueryParameterSort     = "sort"
+	QueryParameterUserID   = "user_id"
+	QueryParameterStatus   = "status"
+	QueryParameterUpcoming = "upcoming"
+	QueryParameterMode     = "mode"
+	QueryParameterMetadata = "metadata"
 )
 
-var ErrNotATime = errors.New("expected an RFC3339 string or Unix timestamp")
+const (
+	SortStartAscending  = "starts_at"
+	SortStartDescending = "-starts_at"
+)
+
+const (
+	RangeModeOverlap   = "overlap"
+	RangeModeContained = "contained"
+)
+
+const (
+	maxResourcesPerAppointment = 10
+	maxNotesLength             = 2000
+	maxMetadataEntries         = 20
+	maxMetadataValueLength     = 256
+)
+
+// Metadata keys are short and can't hold a colon, so metadata filters of
+// key:value are never ambiguous.
+var metadataKey = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+var (
+	ErrNotATime   = errors.New("expected an RFC3339 string or Unix timestamp")
+	ErrNotALimit  = errors.New("expected a positive integer")
+	ErrNotACursor = errors.New("expected a cursor from a previous response")
+	ErrNotASort   = fmt.Errorf("expected %q or %q", SortStartAscending, SortStartDescending)
+	ErrNotABool   = errors.New("expected true or false")
+	ErrNotAMode   = fmt.Errorf("expected %q or %q", RangeModeOverlap, RangeModeContained)
+
+	ErrNotAMetadataFilter = errors.New("expected key:value, where the key is at most 64 letters, digits, '_', '.' or '-'")
+)
 
 type AppointmentDTO struct {
 	ID        string    `json:"id"`
@@ -26,75 +73,386 @@ type AppointmentDTO struct {
 	UserID    string    `json:"user_id"`
 	Start     time.Time `json:"starts_at"`
 	End       time.Time `json:"ends_at"`
+	Status    string    `json:"status,omitempty"`
+	Version   int64     `json:"version,omitempty"`
+
+	// ResourceIDs are the rooms and equipment the appointment claims.
+	ResourceIDs []string `json:"resource_ids,omitempty"`
+
+	Notes    string            `json:"notes,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// AppointmentChangesDTO reschedules an appointment, given both times, or
+// changes its notes or metadata. The metadata given replaces all of it.
+type AppointmentChangesDTO struct {
+	Start    *time.Time        `json:"starts_at"`
+	End      *time.Time        `json:"ends_at"`
+	Notes    *string           `json:"notes"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// AuditEntryDTO is one change in an appointment's history. Before is left
+// out of the change that created it.
+type AuditEntryDTO struct {
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor,omitempty"`
+	Role      string          `json:"role,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	At        time.Time       `json:"at"`
+	Before    *AppointmentDTO `json:"before,omitempty"`
+	After     *AppointmentDTO `json:"after"`
+}
+
+type AppointmentHistoryDTO struct {
+	Entries []AuditEntryDTO `json:"entries"`
+}
+
+type AppointmentListDTO struct {
+	Appointments []AppointmentDTO `json:"appointments"`
+	NextCursor   string           `json:"next_cursor,omitempty"`
 }
 
 type AppointmentService interface {
-	Create(ctx context.Context, apt appointment.Appointment) error
-	FindByTrainerID(ctx context.Context, trainerID string) ([]appointment.Appointment, error)
-	FindByTrainerIDInRange(ctx context.Context, trainerID string, timeRange appointment.Range) ([]appointment.Appointment, error)
+	Create(ctx context.Context, apt appointment.Appointment) (appointment.Appointment, error)
+	Get(ctx context.Context, id string) (appointment.Appointment, error)
+	Update(ctx context.Context, id string, changes appointment.Changes, version int64) (appointment.Appointment, error)
+	Cancel(ctx context.Context, id string, version int64) (appointment.Appointment, error)
+	History(ctx context.Context, id string) ([]appointment.AuditEntry, error)
+	FindByTrainerID(
+		ctx context.Context,
+		trainerID string,
+		filter appointment.Filter,
+		page appointment.Page,
+	) (appointment.Listing, error)
+	FindByTrainerIDInRange(
+		ctx context.Context,
+		trainerID string,
+		timeRange appointment.Range,
+		filter appointment.Filter,
+		page appointment.Page,
+	) (appointment.Listing, error)
+	FindByResourceID(
+		ctx context.Context,
+		resourceID string,
+		filter appointment.Filter,
+		page appointment.Page,
+	) (appointment.Listing, error)
+	FindByResourceIDInRange(
+		ctx context.Context,
+		resourceID string,
+		timeRange appointment.Range,
+		filter appointment.Filter,
+		page appointment.Page,
+	) (appointment.Listing, error)
 }
 
-func Health() Handler {
+// Health fails once the server begins draining, so load balancers stop
+// sending it requests.
+func Health(readiness *Readiness) Handler {
 	return func(r Request) (Response, error) {
+		if !readiness.Ready() {
+			return ProblemResponse(NewProblem(ProblemUnavailable, "server is shutting down")), nil
+		}
+
 		return NoContent(), nil
 	}
 }
 
+// CreateAppointment stores an appointment at an ID generated by the server;
+// consumers choosing their own ID must PUT it at that ID instead.
 func CreateAppointment(svc AppointmentService) Handler {
 	return func(r Request) (Response, error) {
 		dto, err := ParseBody[AppointmentDTO](r)
 		if err != nil {
-			return BadRequest("invalid appointment body"), nil
+			return problemOrError(err)
+		}
+
+		if !empty.String(dto.ID) {
+			return problemOrError(invalidField("id", errors.New("is generated by the server; PUT the appointment at /appointment/:id to choose it")))
 		}
 
-		apt, err := EnsureValidAppointment(dto)
+		return createAppointment(r, svc, dto)
+	}
+}
+
+// CreateAppointmentWithID stores an appointment at the ID in the path, failing
+// if it's taken, so retrying the same request can never book twice.
+func CreateAppointmentWithID(svc AppointmentService) Handler {
+	return func(r Request) (Response, error) {
+		dto, err := ParseBody[AppointmentDTO](r)
 		if err != nil {
-			return BadRequest(err.Error()), nil
-		}
-
-		if err := svc.Create(r.Context, apt); err != nil {
-			switch {
-			case errors.Is(err, appointment.ErrInvalidDateRange),
-				errors.Is(err, appointment.ErrOutsideBusinessHours):
-				return BadRequest(err.Error()), nil
-			case errors.Is(err, appointment.ErrScheduleConflict),
-				errors.Is(err, appointment.ErrIDTaken):
-				return Conflict(err.Error()), nil
-			default:
-				return Response{}, err
-			}
+			return problemOrError(err)
 		}
 
-		return NoContent(), nil
+		id := r.PathParameters[PathParameterID]
+		if !empty.String(dto.ID) && dto.ID != id {
+			return problemOrError(invalidField("id", errors.New("must match the ID in the path")))
+		}
+		dto.ID = id
+
+		return createAppointment(r, svc, dto)
+	}
+}
+
+func createAppointment(r Request, svc AppointmentService, dto AppointmentDTO) (Response, error) {
+	if r.Identity != nil && r.Identity.Role == auth.RoleMember && empty.String(dto.UserID) {
+		dto.UserID = r.Identity.Subject
+	}
+
+	apt, err := EnsureValidAppointment(dto)
+	if err != nil {
+		return problemOrError(err)
+	}
+
+	resource := auth.Resource{TrainerID: apt.TrainerID, UserID: apt.UserID}
+	if resp, denied := authorize(r, auth.ActionCreateAppointment, resource); denied {
+		return resp, nil
+	}
+
+	created, err := svc.Create(auditContext(r), apt)
+	if err != nil {
+		return problemOrError(err)
+	}
+
+	resp := appointmentResponse(created, http.StatusCreated)
+	resp.Headers.Set("Location", "/appointment/"+url.PathEscape(created.ID))
+
+	return resp, nil
+}
+
+func GetAppointment(svc AppointmentService) Handler {
+	return func(r Request) (Response, error) {
+		apt, resp, err := loadAppointment(r, svc, auth.ActionGetAppointment)
+		if resp != nil {
+			return *resp, err
+		}
+
+		if resp, ok := notModified(r, appointmentETag(apt)); ok {
+			return resp, nil
+		}
+
+		return appointmentResponse(apt, http.StatusOK), nil
+	}
+}
+
+// UpdateAppointment moves an appointment to new times or changes its notes
+// and metadata. The consumer must send the ETag it last saw in If-Match.
+func UpdateAppointment(svc AppointmentService) Handler {
+	return func(r Request) (Response, error) {
+		current, stop, err := loadAppointment(r, svc, auth.ActionUpdateAppointment)
+		if stop != nil {
+			return *stop, err
+		}
+
+		version, resp, ok := ifMatchVersion(r, current)
+		if !ok {
+			return resp, nil
+		}
+
+		dto, err := ParseBody[AppointmentChangesDTO](r)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		changes, err := EnsureValidChanges(dto)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		updated, err := svc.Update(auditContext(r), current.ID, changes, version)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		return appointmentResponse(updated, http.StatusOK), nil
+	}
+}
+
+// CancelAppointment cancels an appointment. The consumer must send the ETag it
+// last saw in If-Match.
+func CancelAppointment(svc AppointmentService) Handler {
+	return func(r Request) (Response, error) {
+		current, stop, err := loadAppointment(r, svc, auth.ActionCancelAppointment)
+		if stop != nil {
+			return *stop, err
+		}
+
+		version, resp, ok := ifMatchVersion(r, current)
+		if !ok {
+			return resp, nil
+		}
+
+		cancelled, err := svc.Cancel(auditContext(r), current.ID, version)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		return appointmentResponse(cancelled, http.StatusOK), nil
+	}
+}
+
+// GetAppointmentHistory lists every change made to an appointment, oldest
+// first, to whoever may get the appointment.
+func GetAppointmentHistory(svc AppointmentService) Handler {
+	return func(r Request) (Response, error) {
+		apt, resp, err := loadAppointment(r, svc, auth.ActionGetHistory)
+		if resp != nil {
+			return *resp, err
+		}
+
+		entries, err := svc.History(r.Context, apt.ID)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		dto := AppointmentHistoryDTO{Entries: make([]AuditEntryDTO, 0, len(entries))}
+		for _, entry := range entries {
+			dto.Entries = append(dto.Entries, auditEntryToDTO(entry))
+		}
+
+		return OK(dto), nil
+	}
+}
+
+// The audit log records who made each change, and in which request.
+func auditContext(r Request) context.Context {
+	source := audit.Source{RequestID: RequestIDFromContext(r.Context)}
+	if r.Identity != nil {
+		source.Actor, source.Role = r.Identity.Subject, string(r.Identity.Role)
+	}
+
+	return audit.WithSource(r.Context, source)
+}
+
+// Loads the appointment in the path. If it doesn't exist or the caller may
+// not act on it, the response to send instead is returned, along with any
+// error the handler should fail with.
+func loadAppointment(r Request, svc AppointmentService, action auth.Action) (appointment.Appointment, *Response, error) {
+	apt, err := svc.Get(r.Context, r.PathParameters[PathParameterID])
+	if err != nil {
+		resp, err := problemOrError(err)
+
+		return appointment.Appointment{}, &resp, err
 	}
+
+	resource := auth.Resource{TrainerID: apt.TrainerID, UserID: apt.UserID}
+	if resp, denied := authorize(r, action, resource); denied {
+		return appointment.Appointment{}, &resp, nil
+	}
+
+	return apt, nil, nil
 }
 
 func FindAppointmentsForTrainer(svc AppointmentService) Handler {
 	return func(r Request) (Response, error) {
-		trainerID, ok := r.PathParameters[PathParameterTrainerID]
+		trainerID, ok := r.PathParameters[PathParameterID]
+		if !ok {
+			return ProblemResponse(NewProblem(ProblemNoTrainerID, "no trainer ID provided")), nil
+		}
+
+		filter, err := parseFilter(r)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		if r.Identity != nil && r.Identity.Role == auth.RoleMember && empty.String(filter.UserID) {
+			filter.UserID = r.Identity.Subject
+		}
+
+		resource := auth.Resource{TrainerID: trainerID, UserID: filter.UserID}
+		if resp, denied := authorize(r, auth.ActionListAppointments, resource); denied {
+			return resp, nil
+		}
+
+		page, err := parsePage(r)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		if r.QueryParameters.Has(QueryParameterStart) || r.QueryParameters.Has(QueryParameterEnd) {
+			return findAppointmentsForTrainerInRange(r, svc, trainerID, filter, page)
+		}
+
+		return findAppointmentsByTrainerID(r, svc, trainerID, filter, page)
+	}
+}
+
+// FindAppointmentsForResource lists the appointments claiming a room or piece
+// of equipment, optionally within a time range, like a trainer's calendar.
+func FindAppointmentsForResource(svc AppointmentService) Handler {
+	return func(r Request) (Response, error) {
+		resourceID, ok := r.PathParameters[PathParameterID]
 		if !ok {
-			return BadRequest("no trainer ID provided"), nil
+			return ProblemResponse(NewProblem(ProblemNoResourceID, "no resource ID provided")), nil
+		}
+
+		filter, err := parseFilter(r)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		if r.Identity != nil && r.Identity.Role == auth.RoleMember && empty.String(filter.UserID) {
+			filter.UserID = r.Identity.Subject
+		}
+
+		resource := auth.Resource{ResourceID: resourceID, UserID: filter.UserID}
+		if resp, denied := authorize(r, auth.ActionListResourceAppointments, resource); denied {
+			return resp, nil
 		}
 
+		page, err := parsePage(r)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		var listing appointment.Listing
 		if r.QueryParameters.Has(QueryParameterStart) || r.QueryParameters.Has(QueryParameterEnd) {
-			return findAppointmentsForTrainerInRange(r, svc, trainerID)
+			timeRange, err := parseRange(r)
+			if err != nil {
+				return problemOrError(err)
+			}
+
+			listing, err = svc.FindByResourceIDInRange(r.Context, resourceID, timeRange, filter, page)
+			if err != nil {
+				return problemOrError(err)
+			}
+		} else {
+			listing, err = svc.FindByResourceID(r.Context, resourceID, filter, page)
+			if err != nil {
+				return problemOrError(err)
+			}
 		}
 
-		return findAppointmentsByTrainerID(r.Context, svc, trainerID)
+		return listingResponse(r, listing)
 	}
 }
 
+// EnsureValidAppointment returns a *ValidationError naming every invalid field.
 func EnsureValidAppointment(dto AppointmentDTO) (appointment.Appointment, error) {
+	var fields []FieldError
 	if empty.String(dto.TrainerID) {
-		return appointment.Appointment{}, errors.New("trainer is required")
+		fields = append(fields, FieldError{Field: "trainer_id", Detail: "trainer is required"})
 	}
 
 	if empty.String(dto.UserID) {
-		return appointment.Appointment{}, errors.New("user is required")
+		fields = append(fields, FieldError{Field: "user_id", Detail: "user is required"})
+	}
+
+	if dto.Start.IsZero() {
+		fields = append(fields, FieldError{Field: "starts_at", Detail: "start time is required"})
 	}
 
-	if dto.Start.IsZero() || dto.End.IsZero() {
-		return appointment.Appointment{}, errors.New("time range is required")
+	if dto.End.IsZero() {
+		fields = append(fields, FieldError{Field: "ends_at", Detail: "end time is required"})
+	}
+
+	fields = append(fields, validateResourceIDs(dto.ResourceIDs)...)
+	fields = append(fields, validateNotes(dto.Notes)...)
+	fields = append(fields, validateMetadata(dto.Metadata)...)
+
+	if len(fields) > 0 {
+		return appointment.Appointment{}, &ValidationError{Fields: fields}
 	}
 
 	id := dto.ID
@@ -103,60 +461,356 @@ func EnsureValidAppointment(dto AppointmentDTO) (appointment.Appointment, error)
 	}
 
 	return appointment.Appointment{
-		ID:        id,
-		TrainerID: dto.TrainerID,
-		UserID:    dto.UserID,
-		Start:     dto.Start,
-		End:       dto.End,
+		ID:          id,
+		TrainerID:   dto.TrainerID,
+		UserID:      dto.UserID,
+		Start:       dto.Start,
+		End:         dto.End,
+		ResourceIDs: dto.ResourceIDs,
+		Notes:       dto.Notes,
+		Metadata:    dto.Metadata,
 	}, nil
 }
 
+// EnsureValidChanges returns a *ValidationError naming every invalid field.
+// A body changing nothing is treated as a reschedule missing its times.
+func EnsureValidChanges(dto AppointmentChangesDTO) (appointment.Changes, error) {
+	var (
+		fields  []FieldError
+		changes appointment.Changes
+	)
+	rescheduling := dto.Start != nil || dto.End != nil || (dto.Notes == nil && dto.Metadata == nil)
+	if rescheduling && (dto.Start == nil || dto.Start.IsZero()) {
+		fields = append(fields, FieldError{Field: "starts_at", Detail: "start time is required"})
+	} else if rescheduling {
+		changes.Start = *dto.Start
+	}
+
+	if rescheduling && (dto.End == nil || dto.End.IsZero()) {
+		fields = append(fields, FieldError{Field: "ends_at", Detail: "end time is required"})
+	} else if rescheduling {
+		changes.End = *dto.End
+	}
+
+	if dto.Notes != nil {
+		fields = append(fields, validateNotes(*dto.Notes)...)
+		changes.Notes = dto.Notes
+	}
+
+	if dto.Metadata != nil {
+		fields = append(fields, validateMetadata(dto.Metadata)...)
+		changes.Metadata = dto.Metadata
+	}
+
+	if len(fields) > 0 {
+		return appointment.Changes{}, &ValidationError{Fields: fields}
+	}
+
+	return changes, nil
+}
+
+func validateNotes(notes string) []FieldError {
+	if utf8.RuneCountInString(notes) > maxNotesLength {
+		return []FieldError{{Field: "notes", Detail: fmt.Sprintf("notes may be at most %d characters", maxNotesLength)}}
+	}
+
+	return nil
+}
+
+func validateMetadata(metadata map[string]string) []FieldError {
+	if len(metadata) > maxMetadataEntries {
+		return []FieldError{{Field: "metadata", Detail: fmt.Sprintf("at most %d keys are allowed", maxMetadataEntries)}}
+	}
+
+	// Sorted, so the same body always fails the same way.
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fields []FieldError
+	for _, key := range keys {
+		if !metadataKey.MatchString(key) {
+			fields = append(fields, FieldError{
+				Field:  "metadata",
+				Detail: fmt.Sprintf("key %q must be at most 64 letters, digits, '_', '.' or '-'", key),
+			})
+		} else if utf8.RuneCountInString(metadata[key]) > maxMetadataValueLength {
+			fields = append(fields, FieldError{
+				Field:  "metadata." + key,
+				Detail: fmt.Sprintf("value may be at most %d characters", maxMetadataValueLength),
+			})
+		}
+	}
+
+	return fields
+}
+
+func validateResourceIDs(ids []string) []FieldError {
+	if len(ids) > maxResourcesPerAppointment {
+		return []FieldError{{Field: "resource_ids", Detail: fmt.Sprintf("an appointment may claim at most %d resources", maxResourcesPerAppointment)}}
+	}
+
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if empty.String(id) {
+			return []FieldError{{Field: "resource_ids", Detail: "resource IDs may not be empty"}}
+		}
+
+		if seen[id] {
+			return []FieldError{{Field: "resource_ids", Detail: fmt.Sprintf("resource %q is claimed more than once", id)}}
+		}
+		seen[id] = true
+	}
+
+	return nil
+}
+
 func findAppointmentsForTrainerInRange(
 	r Request,
 	svc AppointmentService,
 	trainerID string,
+	filter appointment.Filter,
+	page appointment.Page,
+) (Response, error) {
+	timeRange, err := parseRange(r)
+	if err != nil {
+		return problemOrError(err)
+	}
+
+	listing, err := svc.FindByTrainerIDInRange(r.Context, trainerID, timeRange, filter, page)
+	if err != nil {
+		return problemOrError(err)
+	}
+
+	return listingResponse(r, listing)
+}
+
+func findAppointmentsByTrainerID(
+	r Request,
+	svc AppointmentService,
+	trainerID string,
+	filter appointment.Filter,
+	page appointment.Page,
 ) (Response, error) {
+	listing, err := svc.FindByTrainerID(r.Context, trainerID, filter, page)
+	if err != nil {
+		return problemOrError(err)
+	}
+
+	return listingResponse(r, listing)
+}
+
+// Consumers polling a trainer's calendar can send the last listing's ETag in
+// If-None-Match to get a 304 when nothing has changed.
+func listingResponse(r Request, listing appointment.Listing) (Response, error) {
+	resp, etag, err := contentETag(OK(listingToDTO(listing)))
+	if err != nil {
+		return Response{}, err
+	}
+
+	if unchanged, ok := notModified(r, etag); ok {
+		return unchanged, nil
+	}
+
+	return resp, nil
+}
+
+func parseRange(r Request) (appointment.Range, error) {
 	start, err := parseTime(r.QueryParameters.Get(QueryParameterStart))
 	if err != nil {
-		return BadRequest(fmt.Sprintf("bad start time - %s", err)), nil
+		return appointment.Range{}, invalidField(QueryParameterStart, err)
 	}
 
 	end, err := parseTime(r.QueryParameters.Get(QueryParameterEnd))
 	if err != nil {
-		return BadRequest(fmt.Sprintf("bad end time - %s", err)), nil
+		return appointment.Range{}, invalidField(QueryParameterEnd, err)
 	}
 
-	timeRange := appointment.Range{
+	mode, err := parseRangeMode(r.QueryParameters.Get(QueryParameterMode))
+	if err != nil {
+		return appointment.Range{}, invalidField(QueryParameterMode, err)
+	}
+
+	return appointment.Range{
 		Start: start,
 		End:   end,
+		Mode:  mode,
+	}, nil
+}
+
+func appointmentResponse(apt appointment.Appointment, status int) Response {
+	resp := MakeResponse(appointmentToDTO(apt), status)
+	resp.Headers.Set(HeaderETag, appointmentETag(apt))
+
+	return resp
+}
+
+func parsePage(r Request) (appointment.Page, error) {
+	var page appointment.Page
+	if s := r.QueryParameters.Get(QueryParameterLimit); !empty.String(s) {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return appointment.Page{}, invalidField(QueryParameterLimit, ErrNotALimit)
+		}
+
+		page.Limit = limit
 	}
-	apts, err := svc.FindByTrainerIDInRange(r.Context, trainerID, timeRange)
-	if err != nil {
-		switch {
-		case errors.Is(err, appointment.ErrNoTrainerID),
-			errors.Is(err, appointment.ErrInvalidDateRange):
-			return BadRequest(err.Error()), nil
+
+	if s := r.QueryParameters.Get(QueryParameterCursor); !empty.String(s) {
+		cursor, err := decodeCursor(s)
+		if err != nil {
+			return appointment.Page{}, invalidField(QueryParameterCursor, err)
 		}
 
-		return Response{}, err
+		page.After = &cursor
 	}
 
-	return OK(apts), nil
+	switch r.QueryParameters.Get(QueryParameterSort) {
+	case "", SortStartAscending:
+		page.Sort = appointment.SortStartAscending
+	case SortStartDescending:
+		page.Sort = appointment.SortStartDescending
+	default:
+		return appointment.Page{}, invalidField(QueryParameterSort, ErrNotASort)
+	}
 
+	return page, nil
 }
 
-func findAppointmentsByTrainerID(ctx context.Context, svc AppointmentService, trainerID string) (Response, error) {
-	apts, err := svc.FindByTrainerID(ctx, trainerID)
-	if err != nil {
-		switch {
-		case errors.Is(err, appointment.ErrNoTrainerID):
-			return BadRequest(err.Error()), nil
+func parseFilter(r Request) (appointment.Filter, error) {
+	filter := appointment.Filter{
+		UserID: strings.TrimSpace(r.QueryParameters.Get(QueryParameterUserID)),
+		Status: appointment.Status(strings.TrimSpace(r.QueryParameters.Get(QueryParameterStatus))),
+	}
+
+	if s := r.QueryParameters.Get(QueryParameterUpcoming); !empty.String(s) {
+		upcoming, err := strconv.ParseBool(s)
+		if err != nil {
+			return appointment.Filter{}, invalidField(QueryParameterUpcoming, ErrNotABool)
 		}
 
-		return Response{}, err
+		filter.Upcoming = upcoming
+	}
+
+	// Each metadata=key:value narrows the listing further.
+	pairs := r.QueryParameters[QueryParameterMetadata]
+	if len(pairs) > maxMetadataEntries {
+		return appointment.Filter{}, invalidField(QueryParameterMetadata,
+			fmt.Errorf("at most %d metadata filters are allowed", maxMetadataEntries))
+	}
+
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, ":")
+		if !ok || !metadataKey.MatchString(key) {
+			return appointment.Filter{}, invalidField(QueryParameterMetadata, ErrNotAMetadataFilter)
+		}
+
+		if filter.Metadata == nil {
+			filter.Metadata = make(map[string]string, len(pairs))
+		}
+
+		if _, ok := filter.Metadata[key]; ok {
+			return appointment.Filter{}, invalidField(QueryParameterMetadata,
+				fmt.Errorf("key %q is filtered more than once", key))
+		}
+		filter.Metadata[key] = value
+	}
+
+	return filter, nil
+}
+
+// Cursors are opaque to consumers: the last appointment's start time and ID,
+// base64 encoded so they can be passed back verbatim as a query parameter.
+func encodeCursor(c appointment.Cursor) string {
+	raw := fmt.Sprintf("%d:%s", c.Start.Unix(), c.ID)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (appointment.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return appointment.Cursor{}, ErrNotACursor
+	}
+
+	timestamp, id, ok := strings.Cut(string(raw), ":")
+	if !ok || empty.String(id) {
+		return appointment.Cursor{}, ErrNotACursor
+	}
+
+	start, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return appointment.Cursor{}, ErrNotACursor
+	}
+
+	return appointment.Cursor{
+		Start: time.Unix(start, 0),
+		ID:    id,
+	}, nil
+}
+
+func listingToDTO(listing appointment.Listing) AppointmentListDTO {
+	dtos := make([]AppointmentDTO, 0, len(listing.Appointments))
+	for _, apt := range listing.Appointments {
+		dtos = append(dtos, appointmentToDTO(apt))
+	}
+
+	dto := AppointmentListDTO{Appointments: dtos}
+	if listing.Next != nil {
+		dto.NextCursor = encodeCursor(*listing.Next)
+	}
+
+	return dto
+}
+
+func appointmentToDTO(apt appointment.Appointment) AppointmentDTO {
+	return AppointmentDTO{
+		ID:          apt.ID,
+		TrainerID:   apt.TrainerID,
+		UserID:      apt.UserID,
+		Start:       apt.Start,
+		End:         apt.End,
+		Status:      string(apt.Status),
+		Version:     apt.Version,
+		ResourceIDs: apt.ResourceIDs,
+		Notes:       apt.Notes,
+		Metadata:    apt.Metadata,
+	}
+}
+
+func auditEntryToDTO(entry appointment.AuditEntry) AuditEntryDTO {
+	dto := AuditEntryDTO{
+		Action:    string(entry.Action),
+		Actor:     entry.Source.Actor,
+		Role:      entry.Source.Role,
+		RequestID: entry.Source.RequestID,
+		At:        entry.At,
+	}
+
+	if entry.Before != nil {
+		before := appointmentToDTO(*entry.Before)
+		dto.Before = &before
+	}
+
+	if entry.After != nil {
+		after := appointmentToDTO(*entry.After)
+		dto.After = &after
+	}
+
+	return dto
+}
+
+func parseRangeMode(s string) (appointment.RangeMode, error) {
+	switch s {
+	case "", RangeModeOverlap:
+		return appointment.RangeOverlap, nil
+	case RangeModeContained:
+		return appointment.RangeContained, nil
 	}
 
-	return OK(apts), nil
+	return 0, ErrNotAMode
 }
 
 func parseTime(s string) (time.Time, error) {
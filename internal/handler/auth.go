@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/standoffvenus/future/internal/auth"
+	"github.com/standoffvenus/future/internal/empty"
+	"github.com/standoffvenus/future/internal/tenant"
+)
+
+const (
+	HeaderAuthorization = "Authorization"
+	HeaderAPIKey        = "X-API-Key"
+)
+
+type Authenticator interface {
+	AuthenticateAPIKey(key string) (auth.Identity, error)
+	AuthenticateBearerToken(token string) (auth.Identity, error)
+}
+
+// Authenticate rejects requests without valid credentials, and otherwise
+// sets the request's Identity to the caller.
+func Authenticate(authenticator Authenticator) Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) (Response, error) {
+			identity, err := authenticate(authenticator, r)
+			if err != nil {
+				if errors.Is(err, auth.ErrNoCredentials) || errors.Is(err, auth.ErrInvalidCredentials) {
+					logger(r.Context).
+						Debug().
+						Err(err).
+						Msg("Rejected unauthenticated request.")
+
+					return problemOrError(err)
+				}
+
+				return Response{}, err
+			}
+
+			// Credentials issued to one gym aren't accepted at another.
+			if err := auth.AuthorizeTenant(&identity, tenant.FromContext(r.Context)); err != nil {
+				logger(r.Context).
+					Debug().
+					Err(err).
+					Msg("Denied request.")
+
+				return problemOrError(err)
+			}
+
+			r.Identity = &identity
+			subjectLogger := logger(r.Context).With().Str("subject", identity.Subject).Logger()
+			r.Context = subjectLogger.WithContext(r.Context)
+
+			return next(r)
+		}
+	}
+}
+
+func authenticate(authenticator Authenticator, r Request) (auth.Identity, error) {
+	if key := r.Headers.Get(HeaderAPIKey); !empty.String(key) {
+		return authenticator.AuthenticateAPIKey(key)
+	}
+
+	scheme, token, ok := strings.Cut(r.Headers.Get(HeaderAuthorization), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") && !empty.String(token) {
+		return authenticator.AuthenticateBearerToken(strings.TrimSpace(token))
+	}
+
+	return auth.Identity{}, auth.ErrNoCredentials
+}
+
+// Consults the access policy, returning the response to send if the caller
+// may not act on the resource.
+func authorize(r Request, action auth.Action, resource auth.Resource) (Response, bool) {
+	err := auth.Authorize(r.Identity, action, resource)
+	if err == nil {
+		return Response{}, false
+	}
+
+	logger(r.Context).
+		Debug().
+		Err(err).
+		Msg("Denied request.")
+
+	if resp, ok := ProblemFor(err); ok {
+		return resp, true
+	}
+
+	return ProblemResponse(NewProblem(ProblemForbidden, err.Error())), true
+}
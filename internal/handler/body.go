@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var (
+	ErrInvalidBody  = errors.New("body must be a single JSON value")
+	ErrBodyTooLarge = errors.New("body too large")
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// ParseBody strictly decodes the request body as a single JSON value. For
+// structs, unknown fields are rejected, and fields that can't be decoded are
+// all reported by their JSON names in a *ValidationError.
+func ParseBody[T any](r Request) (T, error) {
+	defer r.Body.Close()
+
+	var t T
+	raw, err := readSingleValue(r.Body)
+	if err != nil {
+		return t, err
+	}
+
+	v := reflect.ValueOf(&t).Elem()
+	if v.Kind() != reflect.Struct {
+		if err := jsoniter.Unmarshal(raw, &t); err != nil {
+			return t, fmt.Errorf("%w: %s", ErrInvalidBody, err)
+		}
+
+		return t, nil
+	}
+
+	var fields map[string]jsoniter.RawMessage
+	if err := jsoniter.Unmarshal(raw, &fields); err != nil {
+		return t, fmt.Errorf("%w: expected an object", ErrInvalidBody)
+	}
+
+	known := jsonFields(v.Type())
+
+	var invalid []FieldError
+	for name, value := range fields {
+		i, ok := known[name]
+		if !ok {
+			invalid = append(invalid, FieldError{Field: name, Detail: "unknown field"})
+			continue
+		}
+
+		field := v.Field(i)
+		if err := jsoniter.Unmarshal(value, field.Addr().Interface()); err != nil {
+			invalid = append(invalid, FieldError{Field: name, Detail: "expected " + describeType(field.Type())})
+		}
+	}
+
+	if len(invalid) > 0 {
+		sortFieldErrors(invalid)
+		return t, &ValidationError{Fields: invalid}
+	}
+
+	return t, nil
+}
+
+func readSingleValue(body io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+
+	var value json.RawMessage
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBody, err)
+	}
+
+	// Anything but whitespace after the value is rejected, including a
+	// stray closing bracket, which jsoniter's More would miss.
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%w: unexpected data after the JSON value", ErrInvalidBody)
+	}
+
+	return value, nil
+}
+
+// Maps each exported field's JSON name to its index.
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+
+		fields[name] = i
+	}
+
+	return fields
+}
+
+func describeType(t reflect.Type) string {
+	if t == timeType {
+		return "an RFC3339 time"
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Pointer:
+		return describeType(t.Elem())
+	}
+
+	return "a valid value"
+}
+
+// Map iteration is random; keep the reported fields stable.
+func sortFieldErrors(fields []FieldError) {
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+
+	// Read one byte past the limit to tell a body of exactly the limit
+	// apart from one that's too large.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadSingleValue(t *testing.T) {
+	tests := []struct {
+		body  string
+		valid bool
+	}{
+		{body: `{"trainer_id":"a"}`, valid: true},
+		{body: " {\"trainer_id\":\"a\"} \n", valid: true},
+		{body: `["a"]`, valid: true},
+		{body: `{"trainer_id":"a"}}`},
+		{body: `{"trainer_id":"a"}]`},
+		{body: `{"trainer_id":"a"} {}`},
+		{body: `{"trainer_id":"a"} x`},
+		{body: `{"trainer_id":`},
+		{body: ``},
+	}
+
+	for _, test := range tests {
+		_, err := readSingleValue(strings.NewReader(test.body))
+		if test.valid && err != nil {
+			t.Errorf("%q: unexpected error: %v", test.body, err)
+		} else if !test.valid && !errors.Is(err, ErrInvalidBody) {
+			t.Errorf("%q: expected %v, got %v", test.body, ErrInvalidBody, err)
+		}
+	}
+}
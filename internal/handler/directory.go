@@ -0,0 +1,308 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/standoffvenus/future/internal/auth"
+	"github.com/standoffvenus/future/internal/directory"
+	"github.com/standoffvenus/future/internal/empty"
+)
+
+const maxDisplayNameLength = 200
+
+// DirectoryKind is what a directory holds, trainers, members or bookable
+// resources, and how callers are authorized to use it.
+type DirectoryKind struct {
+	// Path is where entries are served, followed by their ID.
+	Path string
+
+	Create auth.Action
+	Get    auth.Action
+	List   auth.Action
+	Update auth.Action
+	Delete auth.Action
+
+	// Resource describes an entry to the access policy.
+	Resource func(id string) auth.Resource
+}
+
+var (
+	Trainers = DirectoryKind{
+		Path:     "/trainer",
+		Create:   auth.ActionCreateTrainer,
+		Get:      auth.ActionGetTrainer,
+		List:     auth.ActionListTrainers,
+		Update:   auth.ActionUpdateTrainer,
+		Delete:   auth.ActionDeleteTrainer,
+		Resource: func(id string) auth.Resource { return auth.Resource{TrainerID: id} },
+	}
+	Members = DirectoryKind{
+		Path:     "/member",
+		Create:   auth.ActionCreateMember,
+		Get:      auth.ActionGetMember,
+		List:     auth.ActionListMembers,
+		Update:   auth.ActionUpdateMember,
+		Delete:   auth.ActionDeleteMember,
+		Resource: func(id string) auth.Resource { return auth.Resource{UserID: id} },
+	}
+	Resources = DirectoryKind{
+		Path:     "/resource",
+		Create:   auth.ActionCreateResource,
+		Get:      auth.ActionGetResource,
+		List:     auth.ActionListResources,
+		Update:   auth.ActionUpdateResource,
+		Delete:   auth.ActionDeleteResource,
+		Resource: func(id string) auth.Resource { return auth.Resource{ResourceID: id} },
+	}
+)
+
+type DirectoryEntryDTO struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	TimeZone    string `json:"time_zone"`
+	Active      bool   `json:"active"`
+}
+
+// NewDirectoryEntryDTO creates an entry, which is active unless it says
+// otherwise.
+type NewDirectoryEntryDTO struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	TimeZone    string `json:"time_zone"`
+	Active      *bool  `json:"active"`
+}
+
+// DirectoryEntryChangesDTO changes only the fields it has.
+type DirectoryEntryChangesDTO struct {
+	DisplayName *string `json:"display_name"`
+	TimeZone    *string `json:"time_zone"`
+	Active      *bool   `json:"active"`
+}
+
+type DirectoryListDTO struct {
+	Entries    []DirectoryEntryDTO `json:"entries"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+type Directory interface {
+	Get(ctx context.Context, id string) (directory.Entry, error)
+	List(ctx context.Context, page directory.Page) (directory.Listing, error)
+	Create(ctx context.Context, entry directory.Entry) (directory.Entry, error)
+	Update(ctx context.Context, entry directory.Entry) (directory.Entry, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// CreateDirectoryEntry adds an entry at the body's ID, if it has one, or at
+// one generated by the server.
+func CreateDirectoryEntry(kind DirectoryKind, dir Directory) Handler {
+	return func(r Request) (Response, error) {
+		dto, err := ParseBody[NewDirectoryEntryDTO](r)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		entry, err := EnsureValidDirectoryEntry(dto)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		if resp, denied := authorize(r, kind.Create, kind.Resource(entry.ID)); denied {
+			return resp, nil
+		}
+
+		created, err := dir.Create(r.Context, entry)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		resp := MakeResponse(directoryEntryToDTO(created), http.StatusCreated)
+		resp.Headers.Set("Location", kind.Path+"/"+url.PathEscape(created.ID))
+
+		return resp, nil
+	}
+}
+
+func GetDirectoryEntry(kind DirectoryKind, dir Directory) Handler {
+	return func(r Request) (Response, error) {
+		id := r.PathParameters[PathParameterID]
+		if resp, denied := authorize(r, kind.Get, kind.Resource(id)); denied {
+			return resp, nil
+		}
+
+		entry, err := dir.Get(r.Context, id)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		return OK(directoryEntryToDTO(entry)), nil
+	}
+}
+
+func ListDirectoryEntries(kind DirectoryKind, dir Directory) Handler {
+	return func(r Request) (Response, error) {
+		if resp, denied := authorize(r, kind.List, auth.Resource{}); denied {
+			return resp, nil
+		}
+
+		page, err := parseDirectoryPage(r)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		listing, err := dir.List(r.Context, page)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		dto := DirectoryListDTO{Entries: make([]DirectoryEntryDTO, 0, len(listing.Entries))}
+		for _, entry := range listing.Entries {
+			dto.Entries = append(dto.Entries, directoryEntryToDTO(entry))
+		}
+
+		if listing.Next != "" {
+			dto.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(listing.Next))
+		}
+
+		return OK(dto), nil
+	}
+}
+
+// UpdateDirectoryEntry changes the fields in the body, leaving the rest as
+// they are. Deactivating an entry keeps its appointments but stops any more
+// being booked for it.
+func UpdateDirectoryEntry(kind DirectoryKind, dir Directory) Handler {
+	return func(r Request) (Response, error) {
+		id := r.PathParameters[PathParameterID]
+		if resp, denied := authorize(r, kind.Update, kind.Resource(id)); denied {
+			return resp, nil
+		}
+
+		dto, err := ParseBody[DirectoryEntryChangesDTO](r)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		entry, err := dir.Get(r.Context, id)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		if dto.DisplayName != nil {
+			entry.DisplayName = strings.TrimSpace(*dto.DisplayName)
+		}
+
+		if dto.TimeZone != nil {
+			entry.TimeZone = strings.TrimSpace(*dto.TimeZone)
+		}
+
+		if dto.Active != nil {
+			entry.Active = *dto.Active
+		}
+
+		if fields := validateDirectoryEntry(entry); len(fields) > 0 {
+			return problemOrError(&ValidationError{Fields: fields})
+		}
+
+		updated, err := dir.Update(r.Context, entry)
+		if err != nil {
+			return problemOrError(err)
+		}
+
+		return OK(directoryEntryToDTO(updated)), nil
+	}
+}
+
+func DeleteDirectoryEntry(kind DirectoryKind, dir Directory) Handler {
+	return func(r Request) (Response, error) {
+		id := r.PathParameters[PathParameterID]
+		if resp, denied := authorize(r, kind.Delete, kind.Resource(id)); denied {
+			return resp, nil
+		}
+
+		if err := dir.Delete(r.Context, id); err != nil {
+			return problemOrError(err)
+		}
+
+		return NoContent(), nil
+	}
+}
+
+// EnsureValidDirectoryEntry returns a *ValidationError naming every invalid
+// field.
+func EnsureValidDirectoryEntry(dto NewDirectoryEntryDTO) (directory.Entry, error) {
+	entry := directory.Entry{
+		ID:          strings.TrimSpace(dto.ID),
+		DisplayName: strings.TrimSpace(dto.DisplayName),
+		TimeZone:    strings.TrimSpace(dto.TimeZone),
+		Active:      dto.Active == nil || *dto.Active,
+	}
+
+	if fields := validateDirectoryEntry(entry); len(fields) > 0 {
+		return directory.Entry{}, &ValidationError{Fields: fields}
+	}
+
+	if empty.String(entry.ID) {
+		entry.ID = uuid.NewString()
+	}
+
+	return entry, nil
+}
+
+func validateDirectoryEntry(entry directory.Entry) []FieldError {
+	var fields []FieldError
+	if empty.String(entry.DisplayName) {
+		fields = append(fields, FieldError{Field: "display_name", Detail: "display name is required"})
+	} else if utf8.RuneCountInString(entry.DisplayName) > maxDisplayNameLength {
+		fields = append(fields, FieldError{Field: "display_name", Detail: "display name may be at most 200 characters"})
+	}
+
+	if empty.String(entry.TimeZone) {
+		fields = append(fields, FieldError{Field: "time_zone", Detail: "time zone is required"})
+	} else if _, err := time.LoadLocation(entry.TimeZone); err != nil {
+		fields = append(fields, FieldError{Field: "time_zone", Detail: "expected an IANA time zone, such as America/Los_Angeles"})
+	}
+
+	return fields
+}
+
+func parseDirectoryPage(r Request) (directory.Page, error) {
+	page := directory.Page{Limit: directory.DefaultPageLimit}
+	if s := r.QueryParameters.Get(QueryParameterLimit); !empty.String(s) {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > directory.MaxPageLimit {
+			return directory.Page{}, invalidField(QueryParameterLimit,
+				fmt.Errorf("expected an integer between 1 and %d", directory.MaxPageLimit))
+		}
+
+		page.Limit = limit
+	}
+
+	if s := r.QueryParameters.Get(QueryParameterCursor); !empty.String(s) {
+		after, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(after) == 0 {
+			return directory.Page{}, invalidField(QueryParameterCursor, ErrNotACursor)
+		}
+
+		page.After = string(after)
+	}
+
+	return page, nil
+}
+
+func directoryEntryToDTO(entry directory.Entry) DirectoryEntryDTO {
+	return DirectoryEntryDTO{
+		ID:          entry.ID,
+		DisplayName: entry.DisplayName,
+		TimeZone:    entry.TimeZone,
+		Active:      entry.Active,
+	}
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/standoffvenus/future/internal/appointment"
+	"github.com/standoffvenus/future/internal/empty"
+)
+
+const (
+	HeaderETag        = "ETag"
+	HeaderIfMatch     = "If-Match"
+	HeaderIfNoneMatch = "If-None-Match"
+)
+
+// An appointment's ETag is its version, which the repository increments on
+// every update.
+func appointmentETag(apt appointment.Appointment) string {
+	return fmt.Sprintf(`"%d"`, apt.Version)
+}
+
+// Tags a listing with an ETag derived from its body, since a listing has no
+// version of its own.
+func contentETag(resp Response) (Response, string, error) {
+	var body []byte
+	if resp.Body != nil {
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return Response{}, "", err
+		}
+
+		body = b
+		resp.Body = bytes.NewReader(body)
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	resp.Headers.Set(HeaderETag, etag)
+
+	return resp, etag, nil
+}
+
+// Answers with a 304 if the consumer's If-None-Match already holds etag.
+// Weak comparison is used, as RFC 9110 requires for If-None-Match.
+func notModified(r Request, etag string) (Response, bool) {
+	if !etagListMatches(r.Headers.Get(HeaderIfNoneMatch), etag, true) {
+		return Response{}, false
+	}
+
+	headers := make(http.Header)
+	headers.Set(HeaderETag, etag)
+
+	return Response{Code: http.StatusNotModified, Headers: headers}, true
+}
+
+// Returns the version the consumer's If-Match expects the appointment to be
+// at, or the problem to answer with if it's missing or no longer matches.
+// Mutations must send If-Match, so two consumers can't overwrite each other.
+func ifMatchVersion(r Request, current appointment.Appointment) (int64, Response, bool) {
+	header := r.Headers.Get(HeaderIfMatch)
+	if empty.String(header) {
+		p := NewProblem(ProblemPreconditionRequired, "send the appointment's ETag in If-Match")
+
+		return 0, ProblemResponse(p), false
+	}
+
+	if !etagListMatches(header, appointmentETag(current), false) {
+		p := NewProblem(ProblemPreconditionFailed, fmt.Sprintf("appointment's ETag is now %s", appointmentETag(current)))
+
+		return 0, ProblemResponse(p), false
+	}
+
+	return current.Version, Response{}, true
+}
+
+// Reports whether a comma-separated list of entity tags, or "*", matches
+// etag. Weak tags only match under weak comparison.
+func etagListMatches(list, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
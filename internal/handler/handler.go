@@ -9,14 +9,25 @@ import (
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/rs/zerolog/log"
+	"github.com/standoffvenus/future/internal/auth"
 )
 
 type Request struct {
 	Context         context.Context
+	Method          string
+	Host            string
+	Path            string
 	Headers         http.Header
 	PathParameters  map[string]string
 	QueryParameters url.Values
 	Body            io.ReadCloser
+
+	// Route is the endpoint's path pattern, or RouteUnmatched.
+	Route string
+
+	// Identity is the authenticated caller, or nil if the endpoint doesn't
+	// require authentication.
+	Identity *auth.Identity
 }
 
 type Response struct {
@@ -25,10 +36,6 @@ type Response struct {
 	Body    io.Reader
 }
 
-type Error struct {
-	Message string `json:",omitempty"`
-}
-
 type Handler func(Request) (Response, error)
 
 func OK[T any](t T) Response {
@@ -39,14 +46,6 @@ func NoContent() Response {
 	return MakeResponse("", http.StatusNoContent)
 }
 
-func BadRequest(msg string) Response {
-	return MakeResponse(Error{Message: msg}, http.StatusBadRequest)
-}
-
-func Conflict(msg string) Response {
-	return MakeResponse(Error{Message: msg}, http.StatusConflict)
-}
-
 func MakeResponse[T any](t T, status int) Response {
 	var buffer bytes.Buffer
 	if err := jsoniter.NewEncoder(&buffer).Encode(t); err != nil {
@@ -67,14 +66,3 @@ func MakeResponse[T any](t T, status int) Response {
 		Headers: headers,
 	}
 }
-
-func ParseBody[T any](r Request) (T, error) {
-	defer r.Body.Close()
-
-	var t T
-	if err := jsoniter.NewDecoder(r.Body).Decode(&t); err != nil {
-		return t, err
-	}
-
-	return t, nil
-}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/standoffvenus/future/internal/build"
+)
+
+const (
+	CheckStatusOK       = "ok"
+	CheckStatusFailing  = "failing"
+	CheckStatusDraining = "draining"
+)
+
+// Check is a dependency the server can't serve requests without.
+type Check struct {
+	Name  string
+	Check func(context.Context) error
+}
+
+type CheckDTO struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+type BuildDTO struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
+type HealthDTO struct {
+	Status string              `json:"status"`
+	Checks map[string]CheckDTO `json:"checks,omitempty"`
+	Build  BuildDTO            `json:"build"`
+}
+
+// Live only reports that the server is running; failing dependencies are for
+// Ready to report, since restarting the server won't fix them.
+func Live() Handler {
+	return func(r Request) (Response, error) {
+		return OK(HealthDTO{Status: CheckStatusOK, Build: buildDTO()}), nil
+	}
+}
+
+// Ready runs every check concurrently, each bounded by timeout, and fails if
+// any of them does or the server is draining.
+func Ready(readiness *Readiness, timeout time.Duration, checks ...Check) Handler {
+	return func(r Request) (Response, error) {
+		dto := HealthDTO{
+			Status: CheckStatusOK,
+			Checks: runChecks(r.Context, timeout, checks),
+			Build:  buildDTO(),
+		}
+
+		for _, c := range dto.Checks {
+			if c.Status != CheckStatusOK {
+				dto.Status = CheckStatusFailing
+			}
+		}
+
+		if !readiness.Ready() {
+			dto.Status = CheckStatusDraining
+		}
+
+		if dto.Status != CheckStatusOK {
+			return MakeResponse(dto, http.StatusServiceUnavailable), nil
+		}
+
+		return OK(dto), nil
+	}
+}
+
+func runChecks(ctx context.Context, timeout time.Duration, checks []Check) map[string]CheckDTO {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]CheckDTO, len(checks))
+	)
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			result := CheckDTO{Status: CheckStatusOK}
+			if err := c.Check(checkCtx); err != nil {
+				logger(ctx).
+					Warn().
+					Err(err).
+					Fields(map[string]any{
+						"check": c.Name,
+					}).
+					Msg("Readiness check failed.")
+
+				result = CheckDTO{Status: CheckStatusFailing, Error: err.Error()}
+			}
+			result.DurationMS = float64(time.Since(start)) / float64(time.Millisecond)
+
+			mu.Lock()
+			results[c.Name] = result
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func buildDTO() BuildDTO {
+	return BuildDTO{Version: build.Version, Commit: build.Commit}
+}
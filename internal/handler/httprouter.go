@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/zerolog/log"
@@ -13,7 +14,9 @@ import (
 )
 
 type httprouterRouter struct {
-	router *httprouter.Router
+	router  *httprouter.Router
+	methods map[string]struct{}
+	metrics *RequestMetrics
 
 	oncer        sync.Once
 	shutdownChan chan struct{}
@@ -22,11 +25,14 @@ type httprouterRouter struct {
 
 var _ Router = new(httprouterRouter)
 
-func (r *httprouterRouter) Serve(ctx context.Context, addr string) error {
+// Serve stops accepting connections once ctx is cancelled and the drain's
+// delay has passed, and waits for in-flight requests to finish, for up to the
+// drain's timeout, before returning.
+func (r *httprouterRouter) Serve(ctx context.Context, addr string, drain Drain) error {
 	r.oncer.Do(func() {
 		srv := http.Server{
 			Addr:    addr,
-			Handler: r.router,
+			Handler: r,
 		}
 
 		shutdown := make(chan struct{})
@@ -53,28 +59,119 @@ func (r *httprouterRouter) Serve(ctx context.Context, addr string) error {
 		select {
 		case <-shutdown:
 		case <-ctx.Done():
-			log.Info().Msg("Shutting down server.")
+			log.
+				Info().
+				Fields(map[string]any{
+					"delay":   drain.Delay,
+					"timeout": drain.Timeout,
+				}).
+				Msg("Draining server.")
+
+			if drain.Readiness != nil {
+				drain.Readiness.Drain()
+			}
+
+			// Still serving, while load balancers see /readyz fail.
+			time.Sleep(drain.Delay)
+
+			drainCtx, cancel := context.WithTimeout(context.Background(), drain.Timeout)
+			defer cancel()
+
+			if err := srv.Shutdown(drainCtx); err != nil {
+				log.
+					Warn().
+					Err(err).
+					Msg("Server did not drain in time; closing remaining connections.")
 
-			srv.Close()
+				srv.Close()
+			}
 			<-shutdown
+
+			log.Info().Msg("Server drained.")
 		}
 	})
 
 	return r.err
 }
 
+func (r *httprouterRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w, req = stripTenantPrefix(w, req)
+	r.router.ServeHTTP(w, req)
+}
+
 func (r *httprouterRouter) addHandler(endpoint Endpoint) {
+	if r.router == nil {
+		r.router = httprouter.New()
+		r.methods = make(map[string]struct{})
+	}
+	r.methods[endpoint.Method] = struct{}{}
+
 	r.router.Handle(
 		endpoint.Method,
 		endpoint.Path,
-		makeHTTPRouterHandler(endpoint.Handler))
+		r.instrument(endpoint.Method, endpoint.Path, makeHTTPRouterHandler(endpoint.Path, endpoint.Handler)))
+}
 
+func (r *httprouterRouter) addFallbacks(middleware []Middleware) {
+	if r.router == nil {
+		r.router = httprouter.New()
+	}
+
+	notFound := r.instrumentUnmatched(makeHTTPRouterHandler(RouteUnmatched, Chain(NotFound(), middleware...)))
+	r.router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		notFound(w, req, nil)
+	})
+
+	// httprouter has already set the Allow header by the time these run.
+	r.router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h := Chain(MethodNotAllowed(w.Header().Get("Allow")), middleware...)
+		r.instrumentUnmatched(makeHTTPRouterHandler(RouteUnmatched, h))(w, req, nil)
+	})
+
+	options := r.instrumentUnmatched(makeHTTPRouterHandler(RouteUnmatched, Chain(Options(), middleware...)))
+	r.router.GlobalOPTIONS = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		options(w, req, nil)
+	})
+}
+
+func (r *httprouterRouter) instrument(method, route string, h httprouter.Handle) httprouter.Handle {
+	if r.metrics == nil {
+		return h
+	}
+
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h(recorder, req, p)
+		r.metrics.observe(method, route, recorder.code, time.Since(start))
+	}
+}
+
+// Unmatched requests may use any method, so only those with an endpoint keep
+// theirs as a label.
+func (r *httprouterRouter) instrumentUnmatched(h httprouter.Handle) httprouter.Handle {
+	if r.metrics == nil {
+		return h
+	}
+
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		method := req.Method
+		if _, ok := r.methods[method]; !ok && method != http.MethodOptions {
+			method = "other"
+		}
+
+		r.instrument(method, RouteUnmatched, h)(w, req, p)
+	}
 }
 
-func makeHTTPRouterHandler(h Handler) httprouter.Handle {
+func makeHTTPRouterHandler(route string, h Handler) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		resp, err := h(Request{
 			Context:         r.Context(),
+			Method:          r.Method,
+			Host:            r.Host,
+			Path:            tenantPrefix(r.Context()) + r.URL.Path,
+			Route:           route,
 			PathParameters:  paramsToMap(p),
 			QueryParameters: r.URL.Query(),
 			Headers:         r.Header,
@@ -82,14 +179,23 @@ func makeHTTPRouterHandler(h Handler) httprouter.Handle {
 		})
 
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
 			log.
 				Error().
 				Err(err).
 				Msg("Service encountered error.")
-		} else {
-			w.WriteHeader(resp.Code)
-			writeHeaders(w.Header(), resp.Headers)
+
+			// The request ID middleware tags even failed responses with their ID.
+			requestID := resp.Headers.Get(HeaderRequestID)
+			resp = InternalServerError(requestID)
+			if requestID != "" {
+				resp.Headers.Set(HeaderRequestID, requestID)
+			}
+		}
+
+		// Headers must be set before the status code is written.
+		writeHeaders(w.Header(), resp.Headers)
+		w.WriteHeader(resp.Code)
+		if resp.Body != nil {
 			io.Copy(w, resp.Body)
 		}
 	}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/standoffvenus/future/internal/empty"
+	"github.com/standoffvenus/future/internal/idempotency"
+	"github.com/standoffvenus/future/internal/tenant"
+)
+
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+var ErrNotAnIdempotencyKey = errors.New("expected at most 255 characters")
+
+type IdempotencyStore interface {
+	Claim(ctx context.Context, scope, key string, hash []byte, leasedUntil, expiresAt time.Time) (*idempotency.Response, error)
+	Complete(ctx context.Context, scope, key string, resp idempotency.Response) error
+	Release(ctx context.Context, scope, key string) error
+}
+
+// Idempotency answers retries of a request sent with an Idempotency-Key
+// header with the first response to it, for ttl. Keys are scoped to the
+// tenant and caller, and reusing one for a different request is rejected.
+// Requests that fail with a server error give their key up, so they can be
+// retried, and so do those that haven't been answered within lease.
+func Idempotency(store IdempotencyStore, ttl, lease time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) (Response, error) {
+			key := r.Headers.Get(HeaderIdempotencyKey)
+			if empty.String(key) {
+				return next(r)
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				return problemOrError(invalidField(HeaderIdempotencyKey, ErrNotAnIdempotencyKey))
+			}
+
+			var body []byte
+			if r.Body != nil {
+				b, err := io.ReadAll(r.Body)
+				if err != nil {
+					return problemOrError(err)
+				}
+
+				body = b
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+
+			scope := tenant.FromContext(r.Context) + "/"
+			if r.Identity != nil {
+				scope += r.Identity.Subject
+			}
+
+			hash := idempotency.HashRequest(r.Method, r.Path, body)
+			now := time.Now()
+			stored, err := store.Claim(r.Context, scope, key, hash, now.Add(lease), now.Add(ttl))
+			if err != nil {
+				return problemOrError(err)
+			}
+
+			if stored != nil {
+				return replay(*stored), nil
+			}
+
+			resp, err := next(r)
+
+			// The outcome is recorded even if the caller has gone away, since
+			// it's the caller's retry that needs it.
+			ctx := context.Background()
+			if err != nil || resp.Code >= http.StatusInternalServerError {
+				if releaseErr := store.Release(ctx, scope, key); releaseErr != nil {
+					logger(r.Context).
+						Error().
+						Err(releaseErr).
+						Msg("Could not release idempotency key.")
+				}
+
+				return resp, err
+			}
+
+			var respBody []byte
+			if resp.Body != nil {
+				if respBody, err = io.ReadAll(resp.Body); err != nil {
+					return Response{}, err
+				}
+
+				resp.Body = bytes.NewReader(respBody)
+			}
+
+			completed := idempotency.Response{Code: resp.Code, Headers: resp.Headers, Body: respBody}
+			if err := store.Complete(ctx, scope, key, completed); err != nil {
+				logger(r.Context).
+					Error().
+					Err(err).
+					Msg("Could not store response for idempotency key.")
+
+				// Otherwise retries would be told the request is in progress
+				// until the key expires.
+				if err := store.Release(ctx, scope, key); err != nil {
+					logger(r.Context).
+						Error().
+						Err(err).
+						Msg("Could not release idempotency key.")
+				}
+			}
+
+			return resp, nil
+		}
+	}
+}
+
+func replay(stored idempotency.Response) Response {
+	headers := stored.Headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set(HeaderIdempotentReplayed, "true")
+
+	return Response{
+		Code:    stored.Code,
+		Headers: headers,
+		Body:    bytes.NewReader(stored.Body),
+	}
+}
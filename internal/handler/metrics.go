@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Requests that matched no endpoint are counted under this route, so
+// consumers can't create a series per unknown path.
+const RouteUnmatched = "unmatched"
+
+// RequestMetrics counts the router's requests and their latency by route,
+// the endpoint's path pattern.
+type RequestMetrics struct {
+	Requests *prometheus.CounterVec
+	Duration *prometheus.HistogramVec
+}
+
+func NewRequestMetrics(registerer prometheus.Registerer) *RequestMetrics {
+	factory := promauto.With(registerer)
+
+	return &RequestMetrics{
+		Requests: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests handled, by route and status code.",
+		}, []string{"method", "route", "code"}),
+		Duration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+	}
+}
+
+func (m *RequestMetrics) observe(method, route string, code int, elapsed time.Duration) {
+	m.Requests.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	m.Duration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+// Metrics serves everything gatherer collects, in whichever exposition
+// format the scraper asks for.
+func Metrics(gatherer prometheus.Gatherer) Handler {
+	return func(r Request) (Response, error) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return Response{}, err
+		}
+
+		format := expfmt.Negotiate(r.Headers)
+
+		var buffer bytes.Buffer
+		encoder := expfmt.NewEncoder(&buffer, format)
+		for _, family := range families {
+			if err := encoder.Encode(family); err != nil {
+				return Response{}, err
+			}
+		}
+
+		headers := make(http.Header)
+		headers.Set("Content-Type", string(format))
+
+		return Response{
+			Code:    http.StatusOK,
+			Headers: headers,
+			Body:    &buffer,
+		}, nil
+	}
+}
+
+// Records the status code a handler writes; handlers that never call
+// WriteHeader respond with a 200.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
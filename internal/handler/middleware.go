@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/standoffvenus/future/internal/empty"
+)
+
+const HeaderRequestID = "X-Request-ID"
+
+type Middleware func(Handler) Handler
+
+type requestIDKey struct{}
+
+// Wraps h so the first middleware is the outermost.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+
+	return h
+}
+
+// RequestID tags each request with the consumer's X-Request-ID, or a new one
+// if none was sent, echoes it in the response and attaches a logger carrying
+// it to the request's context.
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) (Response, error) {
+			id := r.Headers.Get(HeaderRequestID)
+			if empty.String(id) {
+				id = uuid.NewString()
+			}
+
+			requestLogger := logger(r.Context).With().Str("request_id", id).Logger()
+			r.Context = requestLogger.WithContext(context.WithValue(r.Context, requestIDKey{}, id))
+
+			resp, err := next(r)
+			if resp.Headers == nil {
+				resp.Headers = make(http.Header)
+			}
+			resp.Headers.Set(HeaderRequestID, id)
+
+			return resp, err
+		}
+	}
+}
+
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) (Response, error) {
+			start := time.Now()
+			resp, err := next(r)
+
+			event := logger(r.Context).Info()
+			if err != nil {
+				event = logger(r.Context).Error().Err(err)
+			}
+
+			event.
+				Fields(map[string]any{
+					"method":   r.Method,
+					"path":     r.Path,
+					"status":   statusCode(resp, err),
+					"duration": time.Since(start),
+				}).
+				Msg("Handled request.")
+
+			return resp, err
+		}
+	}
+}
+
+// Recovery turns a panicking handler into an internal server error.
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) (resp Response, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					logger(r.Context).
+						Error().
+						Fields(map[string]any{
+							"panic": fmt.Sprint(v),
+							"stack": string(debug.Stack()),
+						}).
+						Msg("Handler panicked.")
+
+					resp, err = Response{}, fmt.Errorf("handler panicked: %v", v)
+				}
+			}()
+
+			return next(r)
+		}
+	}
+}
+
+// MaxBodySize fails reading a request body past n bytes with ErrBodyTooLarge.
+func MaxBodySize(n int64) Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) (Response, error) {
+			if r.Body != nil {
+				r.Body = &limitedBody{ReadCloser: r.Body, remaining: n}
+			}
+
+			return next(r)
+		}
+	}
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// Falls back to the global logger when no middleware has attached one.
+func logger(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+
+	return &log.Logger
+}
+
+func statusCode(resp Response, err error) int {
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+
+	return resp.Code
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/standoffvenus/future/internal/appointment"
+	"github.com/standoffvenus/future/internal/auth"
+	"github.com/standoffvenus/future/internal/directory"
+	"github.com/standoffvenus/future/internal/idempotency"
+)
+
+const ContentTypeProblem = "application/problem+json"
+
+// Problem codes are part of the API; consumers switch on them, so existing
+// codes must never change meaning.
+const (
+	ProblemInvalidBody          = "invalid_body"
+	ProblemBodyTooLarge         = "body_too_large"
+	ProblemValidationFailed     = "validation_failed"
+	ProblemInvalidDateRange     = "invalid_date_range"
+	ProblemOutsideBusinessHours = "outside_business_hours"
+	ProblemScheduleConflict     = "schedule_conflict"
+	ProblemIDTaken              = "id_taken"
+	ProblemNoTrainerID          = "no_trainer_id"
+	ProblemNoResourceID         = "no_resource_id"
+	ProblemInvalidPage          = "invalid_page"
+	ProblemInvalidFilter        = "invalid_filter"
+	ProblemUnauthenticated      = "unauthenticated"
+	ProblemForbidden            = "forbidden"
+	ProblemNotFound             = "not_found"
+	ProblemMethodNotAllowed     = "method_not_allowed"
+	ProblemIdempotencyKeyReused = "idempotency_key_reused"
+	ProblemIdempotencyKeyInUse  = "idempotency_key_in_use"
+	ProblemPreconditionFailed   = "precondition_failed"
+	ProblemPreconditionRequired = "precondition_required"
+	ProblemCancelled            = "appointment_cancelled"
+	ProblemUnavailable          = "unavailable"
+	ProblemTenantRequired       = "tenant_required"
+	ProblemUnknownTenant        = "unknown_tenant"
+	ProblemUnknownTrainer       = "unknown_trainer"
+	ProblemInactiveTrainer      = "inactive_trainer"
+	ProblemUnknownMember        = "unknown_member"
+	ProblemInactiveMember       = "inactive_member"
+	ProblemDirectoryIDTaken     = "directory_id_taken"
+	ProblemResourceConflict     = "resource_conflict"
+	ProblemUnknownResource      = "unknown_resource"
+	ProblemInactiveResource     = "inactive_resource"
+	ProblemInternal             = "internal"
+)
+
+// Problem is an RFC 7807 problem details object, extended with a stable
+// code, the request ID and any invalid fields.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Code      string       `json:"code"`
+	Reason    string       `json:"reason,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+}
+
+// FieldError names an invalid body field or query parameter.
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+type ValidationError struct {
+	Fields []FieldError
+}
+
+type problemType struct {
+	Status int
+	Title  string
+}
+
+var problemTypes = map[string]problemType{
+	ProblemInvalidBody:          {http.StatusBadRequest, "Request body is not valid JSON"},
+	ProblemBodyTooLarge:         {http.StatusRequestEntityTooLarge, "Request body too large"},
+	ProblemValidationFailed:     {http.StatusBadRequest, "Request failed validation"},
+	ProblemInvalidDateRange:     {http.StatusBadRequest, "Invalid date range"},
+	ProblemOutsideBusinessHours: {http.StatusBadRequest, "Outside business hours"},
+	ProblemScheduleConflict:     {http.StatusConflict, "Time not available"},
+	ProblemIDTaken:              {http.StatusConflict, "Appointment ID already taken"},
+	ProblemNoTrainerID:          {http.StatusBadRequest, "No trainer ID"},
+	ProblemNoResourceID:         {http.StatusBadRequest, "No resource ID"},
+	ProblemInvalidPage:          {http.StatusBadRequest, "Invalid page"},
+	ProblemInvalidFilter:        {http.StatusBadRequest, "Invalid filter"},
+	ProblemUnauthenticated:      {http.StatusUnauthorized, "Authentication required"},
+	ProblemForbidden:            {http.StatusForbidden, "Not allowed"},
+	ProblemNotFound:             {http.StatusNotFound, "Not found"},
+	ProblemMethodNotAllowed:     {http.StatusMethodNotAllowed, "Method not allowed"},
+	ProblemIdempotencyKeyReused: {http.StatusUnprocessableEntity, "Idempotency key reused"},
+	ProblemIdempotencyKeyInUse:  {http.StatusConflict, "Request in progress"},
+	ProblemPreconditionFailed:   {http.StatusPreconditionFailed, "Appointment has changed"},
+	ProblemPreconditionRequired: {http.StatusPreconditionRequired, "If-Match required"},
+	ProblemCancelled:            {http.StatusConflict, "Appointment cancelled"},
+	ProblemUnavailable:          {http.StatusServiceUnavailable, "Service unavailable"},
+	ProblemTenantRequired:       {http.StatusBadRequest, "Tenant required"},
+	ProblemUnknownTenant:        {http.StatusNotFound, "Unknown tenant"},
+	ProblemUnknownTrainer:       {http.StatusBadRequest, "Unknown trainer"},
+	ProblemInactiveTrainer:      {http.StatusConflict, "Trainer inactive"},
+	ProblemUnknownMember:        {http.StatusBadRequest, "Unknown member"},
+	ProblemInactiveMember:       {http.StatusConflict, "Member inactive"},
+	ProblemDirectoryIDTaken:     {http.StatusConflict, "ID already in the directory"},
+	ProblemResourceConflict:     {http.StatusConflict, "Resource not available"},
+	ProblemUnknownResource:      {http.StatusBadRequest, "Unknown resource"},
+	ProblemInactiveResource:     {http.StatusConflict, "Resource inactive"},
+	ProblemInternal:             {http.StatusInternalServerError, "Internal server error"},
+}
+
+// Domain errors, in the order they're matched against.
+var domainProblems = []struct {
+	Err  error
+	Code string
+}{
+	{ErrInvalidBody, ProblemInvalidBody},
+	{ErrBodyTooLarge, ProblemBodyTooLarge},
+	{appointment.ErrInvalidDateRange, ProblemInvalidDateRange},
+	{appointment.ErrOutsideBusinessHours, ProblemOutsideBusinessHours},
+	{appointment.ErrScheduleConflict, ProblemScheduleConflict},
+	{appointment.ErrIDTaken, ProblemIDTaken},
+	{appointment.ErrResourceConflict, ProblemResourceConflict},
+	{appointment.ErrNoTrainerID, ProblemNoTrainerID},
+	{appointment.ErrNoResourceID, ProblemNoResourceID},
+	{appointment.ErrInvalidPage, ProblemInvalidPage},
+	{appointment.ErrInvalidFilter, ProblemInvalidFilter},
+	{appointment.ErrNotFound, ProblemNotFound},
+	{appointment.ErrVersionMismatch, ProblemPreconditionFailed},
+	{appointment.ErrCancelled, ProblemCancelled},
+	{appointment.ErrUnknownTenant, ProblemUnknownTenant},
+	{appointment.ErrUnknownTrainer, ProblemUnknownTrainer},
+	{appointment.ErrInactiveTrainer, ProblemInactiveTrainer},
+	{appointment.ErrUnknownMember, ProblemUnknownMember},
+	{appointment.ErrInactiveMember, ProblemInactiveMember},
+	{appointment.ErrUnknownResource, ProblemUnknownResource},
+	{appointment.ErrInactiveResource, ProblemInactiveResource},
+	{directory.ErrNotFound, ProblemNotFound},
+	{directory.ErrIDTaken, ProblemDirectoryIDTaken},
+	{ErrTenantRequired, ProblemTenantRequired},
+	{auth.ErrNoCredentials, ProblemUnauthenticated},
+	{auth.ErrInvalidCredentials, ProblemUnauthenticated},
+	{idempotency.ErrKeyReused, ProblemIdempotencyKeyReused},
+	{idempotency.ErrKeyInUse, ProblemIdempotencyKeyInUse},
+}
+
+func NewProblem(code, detail string) Problem {
+	t, ok := problemTypes[code]
+	if !ok {
+		code, t = ProblemInternal, problemTypes[ProblemInternal]
+	}
+
+	return Problem{
+		Type:   "/problems/" + strings.ReplaceAll(code, "_", "-"),
+		Title:  t.Title,
+		Status: t.Status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func ProblemResponse(p Problem) Response {
+	resp := MakeResponse(p, p.Status)
+	resp.Headers.Set("Content-Type", ContentTypeProblem)
+	if p.Status == http.StatusUnauthorized {
+		resp.Headers.Set("WWW-Authenticate", "Bearer")
+	}
+
+	return resp
+}
+
+// ProblemFor converts an error the API knows how to explain into its
+// problem response; any other error is left for the caller to treat as
+// internal.
+func ProblemFor(err error) (Response, bool) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		p := NewProblem(ProblemValidationFailed, validationErr.Error())
+		p.Errors = validationErr.Fields
+
+		return ProblemResponse(p), true
+	}
+
+	var denial *auth.Denial
+	if errors.As(err, &denial) {
+		p := NewProblem(ProblemForbidden, denial.Error())
+		p.Reason = denial.Reason
+
+		return ProblemResponse(p), true
+	}
+
+	for _, dp := range domainProblems {
+		if errors.Is(err, dp.Err) {
+			return ProblemResponse(NewProblem(dp.Code, err.Error())), true
+		}
+	}
+
+	return Response{}, false
+}
+
+// Responds with the error's problem, or fails the request if it has none.
+func problemOrError(err error) (Response, error) {
+	if resp, ok := ProblemFor(err); ok {
+		return resp, nil
+	}
+
+	return Response{}, err
+}
+
+func InternalServerError(requestID string) Response {
+	p := NewProblem(ProblemInternal, "")
+	p.RequestID = requestID
+
+	return ProblemResponse(p)
+}
+
+func invalidField(field string, err error) *ValidationError {
+	return &ValidationError{Fields: []FieldError{{Field: field, Detail: err.Error()}}}
+}
+
+func (e *ValidationError) Error() string {
+	details := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		details = append(details, fmt.Sprintf("%s: %s", f.Field, f.Detail))
+	}
+
+	return "invalid " + strings.Join(details, ", ")
+}
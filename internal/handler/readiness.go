@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Readiness reports whether the server should be sent new requests; it stops
+// being ready as soon as the server starts draining.
+type Readiness struct {
+	draining int32
+}
+
+// Drain configures how Serve stops once its context is cancelled.
+type Drain struct {
+	// Delay is how long the server keeps serving once it's marked as
+	// draining, so load balancers can notice and stop sending it requests
+	// before it stops accepting connections.
+	Delay time.Duration
+
+	// Timeout bounds how long in-flight requests may run once draining
+	// begins, after which their connections are closed.
+	Timeout time.Duration
+
+	// Readiness, if set, is marked as draining when Serve begins draining.
+	Readiness *Readiness
+}
+
+func (r *Readiness) Drain() {
+	atomic.StoreInt32(&r.draining, 1)
+}
+
+func (r *Readiness) Ready() bool {
+	return atomic.LoadInt32(&r.draining) == 0
+}
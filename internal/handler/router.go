@@ -2,25 +2,63 @@ package handler
 
 import (
 	"context"
-
-	"github.com/julienschmidt/httprouter"
+	"fmt"
+	"net/http"
+	"strings"
 )
 
 type Endpoint struct {
-	Path    string
-	Method  string
-	Handler Handler
+	Path       string
+	Method     string
+	Handler    Handler
+	Middleware []Middleware
 }
 
 type Router interface {
-	Serve(ctx context.Context, addr string) error
+	Serve(ctx context.Context, addr string, drain Drain) error
 }
 
-func NewRouter(endpoints []Endpoint) Router {
-	r := httprouterRouter{router: httprouter.New()}
+// Global middleware wraps every endpoint, outside of the endpoint's own
+// middleware, as well as the router's own 404, 405 and OPTIONS responses.
+// Every request is recorded in metrics, if it's set.
+func NewRouter(endpoints []Endpoint, metrics *RequestMetrics, middleware ...Middleware) Router {
+	r := httprouterRouter{metrics: metrics}
 	for _, e := range endpoints {
+		e.Handler = Chain(e.Handler, append(append([]Middleware{}, middleware...), e.Middleware...)...)
 		r.addHandler(e)
 	}
+	r.addFallbacks(middleware)
 
 	return &r
 }
+
+func NotFound() Handler {
+	return func(r Request) (Response, error) {
+		return ProblemResponse(NewProblem(ProblemNotFound, fmt.Sprintf("no endpoint at %s", r.Path))), nil
+	}
+}
+
+// The router sets the Allow header itself; allowed only feeds the body.
+func MethodNotAllowed(allowed string) Handler {
+	return func(r Request) (Response, error) {
+		p := NewProblem(ProblemMethodNotAllowed, fmt.Sprintf("%s is not supported at %s", r.Method, r.Path))
+		p.AllowedMethods = splitAllowed(allowed)
+
+		return ProblemResponse(p), nil
+	}
+}
+
+func Options() Handler {
+	return func(r Request) (Response, error) {
+		return Response{Code: http.StatusNoContent}, nil
+	}
+}
+
+func splitAllowed(allowed string) []string {
+	methods := strings.Split(allowed, ",")
+	for i := range methods {
+		methods[i] = strings.TrimSpace(methods[i])
+	}
+
+	return methods
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/standoffvenus/future/internal/appointment"
+	"github.com/standoffvenus/future/internal/empty"
+	"github.com/standoffvenus/future/internal/tenant"
+)
+
+const (
+	HeaderTenantID = "X-Tenant-ID"
+
+	// TenantPathPrefix names a request's tenant in its path, as in
+	// /t/downtown/appointment. Requests are routed without it.
+	TenantPathPrefix = "/t/"
+)
+
+var ErrTenantRequired = errors.New("name a tenant with a /t/:tenant path prefix, the X-Tenant-ID header or a subdomain")
+
+type pathTenantKey struct{}
+
+// Tenant decides which tenant each request is for: the one named by its
+// /t/:tenant path prefix, else by its X-Tenant-ID header, else by the
+// subdomain of domain it was sent to, else tenant.Default. Requests for a tenant known doesn't recognize are
+// rejected, and the rest carry their tenant in their context.
+func Tenant(domain string, known func(string) bool) Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) (Response, error) {
+			id, named := tenantOf(r, domain)
+			if !known(id) {
+				if !named {
+					return problemOrError(ErrTenantRequired)
+				}
+
+				return problemOrError(fmt.Errorf("%w: %s", appointment.ErrUnknownTenant, id))
+			}
+
+			tenantLogger := logger(r.Context).With().Str("tenant", id).Logger()
+			r.Context = tenant.WithID(tenantLogger.WithContext(r.Context), id)
+
+			return next(r)
+		}
+	}
+}
+
+func tenantOf(r Request, domain string) (string, bool) {
+	if id, ok := r.Context.Value(pathTenantKey{}).(string); ok {
+		return id, true
+	}
+
+	if id := r.Headers.Get(HeaderTenantID); !empty.String(id) {
+		return strings.TrimSpace(id), true
+	}
+
+	if domain != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		// Only a single label is a tenant, so a.b.example.com names none.
+		host, suffix := strings.ToLower(host), "."+strings.ToLower(domain)
+		if sub := strings.TrimSuffix(host, suffix); strings.HasSuffix(host, suffix) && sub != "" && !strings.Contains(sub, ".") {
+			return sub, true
+		}
+	}
+
+	return tenant.Default, false
+}
+
+// Strips the tenant path prefix from req, if it has one, leaving its tenant in
+// the context and the prefix on the Location of redirects and created
+// resources. Requests are routed by the rest of the path, which must be
+// absolute.
+func stripTenantPrefix(w http.ResponseWriter, req *http.Request) (http.ResponseWriter, *http.Request) {
+	if !strings.HasPrefix(req.URL.Path, TenantPathPrefix) {
+		return w, req
+	}
+
+	id, rest := req.URL.Path[len(TenantPathPrefix):], ""
+	if i := strings.IndexByte(id, '/'); i >= 0 {
+		id, rest = id[:i], id[i:]
+	}
+
+	if empty.String(id) || rest == "" {
+		return w, req
+	}
+
+	u := *req.URL
+	u.Path, u.RawPath = rest, ""
+	req = req.WithContext(context.WithValue(req.Context(), pathTenantKey{}, id))
+	req.URL = &u
+
+	return prefixedLocation{ResponseWriter: w, prefix: TenantPathPrefix + id}, req
+}
+
+// The prefix of the request's path, if any, to send back with it.
+func tenantPrefix(ctx context.Context) string {
+	if id, ok := ctx.Value(pathTenantKey{}).(string); ok {
+		return TenantPathPrefix + id
+	}
+
+	return ""
+}
+
+type prefixedLocation struct {
+	http.ResponseWriter
+	prefix string
+}
+
+func (w prefixedLocation) WriteHeader(code int) {
+	if location := w.Header().Get("Location"); strings.HasPrefix(location, "/") {
+		w.Header().Set("Location", w.prefix+location)
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStripTenantPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		tenant string
+		routed string
+	}{
+		{path: "/t/downtown/appointment/1", tenant: "downtown", routed: "/appointment/1"},
+		{path: "/t/downtown/", tenant: "downtown", routed: "/"},
+		{path: "/t/downtown", routed: "/t/downtown"},
+		{path: "/t//appointment", routed: "/t//appointment"},
+		{path: "/appointment/t/1", routed: "/appointment/t/1"},
+	}
+
+	for _, test := range tests {
+		_, req := stripTenantPrefix(httptest.NewRecorder(), httptest.NewRequest("GET", test.path, nil))
+		if req.URL.Path != test.routed {
+			t.Errorf("%s: expected to route %s, got %s", test.path, test.routed, req.URL.Path)
+		}
+
+		if tenant, _ := req.Context().Value(pathTenantKey{}).(string); tenant != test.tenant {
+			t.Errorf("%s: expected tenant %q, got %q", test.path, test.tenant, tenant)
+		}
+	}
+}
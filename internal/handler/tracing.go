@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/standoffvenus/future/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/standoffvenus/future/internal/handler")
+
+// Tracing starts a span for each request, continuing the consumer's trace if
+// it sent a W3C traceparent header. The trace ID is added to the request's
+// logger, so logs can be found from a trace.
+func Tracing() Middleware {
+	return func(next Handler) Handler {
+		return func(r Request) (Response, error) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context, propagation.HeaderCarrier(r.Headers))
+			ctx, span := tracer.Start(ctx, r.Method+" "+r.Route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPMethodKey.String(r.Method),
+					semconv.HTTPRouteKey.String(r.Route),
+					semconv.HTTPTargetKey.String(r.Path)))
+
+			if id := RequestIDFromContext(ctx); id != "" {
+				span.SetAttributes(attribute.String("request_id", id))
+			}
+
+			if sc := span.SpanContext(); sc.HasTraceID() {
+				traceLogger := logger(ctx).With().Str("trace_id", sc.TraceID().String()).Logger()
+				ctx = traceLogger.WithContext(ctx)
+			}
+
+			r.Context = ctx
+			resp, err := next(r)
+
+			code := statusCode(resp, err)
+			span.SetAttributes(semconv.HTTPStatusCodeKey.Int(code))
+			if err == nil && code >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(code))
+			}
+			tracing.End(span, err)
+
+			return resp, err
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/standoffvenus/future/internal/tracing"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/standoffvenus/future/internal/idempotency")
+
+var (
+	ErrKeyReused = errors.New("idempotency key was already used for a different request")
+	ErrKeyInUse  = errors.New("a request with this idempotency key is still in progress")
+
+	errLeaseExpired = errors.New("idempotency key's lease has expired")
+)
+
+// Response is the first response to a request made with an idempotency key,
+// replayed for every retry of that request.
+type Response struct {
+	Code    int
+	Headers http.Header
+	Body    []byte
+}
+
+// SQLStore keeps idempotency keys in the appointments database. Keys are
+// scoped, typically to the caller, so callers can't collide with each other.
+type SQLStore struct {
+	Database *sql.DB
+	Table    string
+}
+
+// HashRequest fingerprints a request so a reused key can be told apart from
+// a retry.
+func HashRequest(method, path string, body []byte) []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, path)
+	h.Write(body)
+
+	return h.Sum(nil)
+}
+
+// Claim reserves the key for the request until expiresAt, and leases it to
+// the request until leasedUntil. If the request has already been answered,
+// its response is returned instead; a key leased to a request that's still
+// running fails with ErrKeyInUse, and a key claimed for a different request
+// with ErrKeyReused. Once a lease runs out without a response, as when the
+// server stopped mid-request, the next retry takes the key over.
+func (s *SQLStore) Claim(ctx context.Context, scope, key string, hash []byte, leasedUntil, expiresAt time.Time) (stored *Response, err error) {
+	ctx, span := tracer.Start(ctx, "SQLStore.Claim")
+	defer func() { tracing.End(span, err) }()
+
+	txn, err := s.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+
+	const Delete = `
+DELETE FROM %s
+ WHERE expires_at <= :now
+`
+
+	now := time.Now()
+	formattedDelete := fmt.Sprintf(Delete, s.Table)
+	if _, err := txn.ExecContext(ctx, formattedDelete, sql.Named("now", now.Unix())); err != nil {
+		return nil, err
+	}
+
+	stored, err = s.get(ctx, txn, scope, key, hash, now)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, errLeaseExpired) {
+		return nil, err
+	} else if err == nil {
+		return stored, txn.Commit()
+	}
+
+	// A key whose lease ran out is claimed again, as if it were new.
+	const Upsert = `
+INSERT INTO %s(scope, key, request_hash, leased_until, expires_at)
+     VALUES (:scope, :key, :request_hash, :leased_until, :expires_at)
+         ON CONFLICT (scope, key)
+         DO UPDATE SET leased_until = excluded.leased_until,
+                       expires_at = excluded.expires_at
+`
+
+	formattedUpsert := fmt.Sprintf(Upsert, s.Table)
+	_, err = txn.ExecContext(ctx, formattedUpsert,
+		sql.Named("scope", scope),
+		sql.Named("key", key),
+		sql.Named("request_hash", hash),
+		sql.Named("leased_until", leasedUntil.Unix()),
+		sql.Named("expires_at", expiresAt.Unix()))
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, txn.Commit()
+}
+
+// Complete stores the response to the request the key was claimed for.
+func (s *SQLStore) Complete(ctx context.Context, scope, key string, resp Response) (err error) {
+	ctx, span := tracer.Start(ctx, "SQLStore.Complete")
+	defer func() { tracing.End(span, err) }()
+
+	const Update = `
+UPDATE %s
+   SET status_code = :status_code,
+       headers = :headers,
+       body = :body
+ WHERE scope = :scope
+   AND key = :key
+`
+
+	headers, err := jsoniter.Marshal(resp.Headers)
+	if err != nil {
+		return err
+	}
+
+	formattedUpdate := fmt.Sprintf(Update, s.Table)
+	_, err = s.Database.ExecContext(ctx, formattedUpdate,
+		sql.Named("status_code", resp.Code),
+		sql.Named("headers", string(headers)),
+		sql.Named("body", resp.Body),
+		sql.Named("scope", scope),
+		sql.Named("key", key))
+
+	return err
+}
+
+// Release gives up a claim whose request failed, so it can be retried.
+func (s *SQLStore) Release(ctx context.Context, scope, key string) (err error) {
+	ctx, span := tracer.Start(ctx, "SQLStore.Release")
+	defer func() { tracing.End(span, err) }()
+
+	const Delete = `
+DELETE FROM %s
+ WHERE scope = :scope
+   AND key = :key
+   AND status_code = 0
+`
+
+	formattedDelete := fmt.Sprintf(Delete, s.Table)
+	_, err = s.Database.ExecContext(ctx, formattedDelete,
+		sql.Named("scope", scope),
+		sql.Named("key", key))
+
+	return err
+}
+
+func (s *SQLStore) get(ctx context.Context, txn *sql.Tx, scope, key string, hash []byte, now time.Time) (*Response, error) {
+	const Query = `
+SELECT request_hash, status_code, headers, body, leased_until
+  FROM %s
+ WHERE scope = :scope
+   AND key = :key
+`
+
+	formattedQuery := fmt.Sprintf(Query, s.Table)
+	row := txn.QueryRowContext(ctx, formattedQuery,
+		sql.Named("scope", scope),
+		sql.Named("key", key))
+
+	var (
+		storedHash []byte
+		code       int
+		headers    string
+		body       []byte
+		leased     int64
+	)
+	if err := row.Scan(&storedHash, &code, &headers, &body, &leased); err != nil {
+		return nil, err
+	}
+
+	if string(storedHash) != string(hash) {
+		return nil, ErrKeyReused
+	}
+
+	// Claimed keys have no status code until their request completes.
+	if code == 0 && now.Unix() < leased {
+		return nil, ErrKeyInUse
+	} else if code == 0 {
+		return nil, errLeaseExpired
+	}
+
+	resp := Response{Code: code, Body: body}
+	if err := jsoniter.UnmarshalFromString(headers, &resp.Headers); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,63 @@
+package idempotency_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/standoffvenus/future/internal/application"
+	"github.com/standoffvenus/future/internal/appointment"
+	"github.com/standoffvenus/future/internal/idempotency"
+)
+
+// A retry gets ErrKeyInUse while the first request holds its lease, and takes
+// the key over once the lease runs out without a response.
+func TestClaimTakesOverExpiredLease(t *testing.T) {
+	const Scope = "default/"
+
+	ctx := context.Background()
+	store := openStore(t)
+	hash := idempotency.HashRequest("POST", "/appointment", []byte("{}"))
+	now := time.Now()
+	leased, expired, expiresAt := now.Add(time.Minute), now.Add(-time.Second), now.Add(time.Hour)
+
+	if _, err := store.Claim(ctx, Scope, "leased", hash, leased, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Claim(ctx, Scope, "leased", hash, leased, expiresAt); !errors.Is(err, idempotency.ErrKeyInUse) {
+		t.Fatalf("expected %v while leased, got %v", idempotency.ErrKeyInUse, err)
+	}
+
+	if _, err := store.Claim(ctx, Scope, "expired", hash, expired, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored, err := store.Claim(ctx, Scope, "expired", hash, leased, expiresAt); err != nil || stored != nil {
+		t.Fatalf("expected to take the key over, got %v, %v", stored, err)
+	}
+
+	if _, err := store.Claim(ctx, Scope, "expired", hash, leased, expiresAt); !errors.Is(err, idempotency.ErrKeyInUse) {
+		t.Fatalf("expected %v once taken over, got %v", idempotency.ErrKeyInUse, err)
+	}
+}
+
+func openStore(t *testing.T) *idempotency.SQLStore {
+	t.Helper()
+
+	ctx := context.Background()
+	db, err := application.OpenSQLite3DB(ctx, filepath.Join(t.TempDir(), "test.sqlite3"), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repository := appointment.SQLRepository{Database: db, Table: "appointments"}
+	if err := repository.CreateSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return &idempotency.SQLStore{Database: db, Table: "appointments_idempotency_keys"}
+}
@@ -0,0 +1,247 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+// PruneInterval is how often delivered events older than the retention are
+// removed.
+const PruneInterval = 10 * time.Minute
+
+type Store interface {
+	Pending(ctx context.Context, limit int) ([]Event, error)
+	Delivered(ctx context.Context, seq int64) error
+	Failed(ctx context.Context, seq int64) error
+	Abandoned(ctx context.Context, seq int64) error
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Metrics counts deliveries to each sink, and the events given up on.
+type Metrics struct {
+	Delivered *prometheus.CounterVec
+	Failed    *prometheus.CounterVec
+	Abandoned prometheus.Counter
+}
+
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	factory := promauto.With(registerer)
+
+	return &Metrics{
+		Delivered: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "outbox_events_delivered_total",
+			Help: "Events delivered, by sink.",
+		}, []string{"sink"}),
+		Failed: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "outbox_delivery_failures_total",
+			Help: "Failed attempts to deliver an event, by sink.",
+		}, []string{"sink"}),
+		Abandoned: factory.NewCounter(prometheus.CounterOpts{
+			Name: "outbox_events_abandoned_total",
+			Help: "Events marked failed after every attempt to deliver them failed.",
+		}),
+	}
+}
+
+// Dispatcher delivers events to every sink, in the order they were written.
+// An event that fails holds back those after it, and is retried with
+// exponential backoff, so a sink that's down never sees events out of order.
+// Retries go to every sink again, including those that already have the
+// event. An event that fails MaxAttempts times is marked failed instead, so
+// one a sink always rejects doesn't hold back the rest forever.
+type Dispatcher struct {
+	Store Store
+	Sinks []Sink
+
+	// Interval is how often the store is checked for new events, BatchSize
+	// how many are read at once, and MaxBackoff the longest wait between
+	// retries.
+	Interval   time.Duration
+	BatchSize  int
+	MaxBackoff time.Duration
+
+	// MaxAttempts is how many times an event is tried before it's marked
+	// failed, or unlimited if 0. Delivered events are pruned once they're
+	// older than Retention, or kept forever if it's 0.
+	MaxAttempts int
+	Retention   time.Duration
+
+	// Metrics, if set, counts every delivery.
+	Metrics *Metrics
+}
+
+// Run delivers events until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var (
+		failures int
+		pruned   time.Time
+	)
+	for {
+		wait := d.Interval
+		if failures > 0 {
+			wait = d.backoff(failures)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if d.Retention > 0 && time.Since(pruned) >= PruneInterval {
+			d.prune(ctx)
+			pruned = time.Now()
+		}
+
+		if err := d.dispatch(ctx); err != nil {
+			failures++
+			if ctx.Err() == nil {
+				log.
+					Warn().
+					Err(err).
+					Fields(map[string]any{
+						"failures": failures,
+						"retry_in": d.backoff(failures).String(),
+					}).
+					Msg("Could not deliver events; retrying.")
+			}
+
+			continue
+		}
+
+		failures = 0
+	}
+}
+
+// Delivers batches of events until none are left, or one fails.
+func (d *Dispatcher) dispatch(ctx context.Context) error {
+	for {
+		events, err := d.Store.Pending(ctx, d.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if err := d.deliver(ctx, event); err != nil {
+				if d.MaxAttempts > 0 && event.Attempts+1 >= d.MaxAttempts && ctx.Err() == nil {
+					if err := d.abandon(ctx, event, err); err != nil {
+						return err
+					}
+
+					continue
+				}
+
+				if failedErr := d.Store.Failed(ctx, event.Seq); failedErr != nil {
+					log.
+						Error().
+						Err(failedErr).
+						Msg("Could not record failed event delivery.")
+				}
+
+				return err
+			}
+
+			if err := d.Store.Delivered(ctx, event.Seq); err != nil {
+				return err
+			}
+		}
+
+		if len(events) < d.BatchSize {
+			return nil
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, event Event) error {
+	for _, sink := range d.Sinks {
+		if err := sink.Deliver(ctx, event); err != nil {
+			if d.Metrics != nil {
+				d.Metrics.Failed.WithLabelValues(sink.Name()).Inc()
+			}
+
+			log.
+				Debug().
+				Err(err).
+				Fields(map[string]any{
+					"sink":       sink.Name(),
+					"event_id":   event.ID,
+					"event_type": event.Type,
+					"attempts":   event.Attempts + 1,
+				}).
+				Msg("Could not deliver event.")
+
+			return err
+		}
+
+		if d.Metrics != nil {
+			d.Metrics.Delivered.WithLabelValues(sink.Name()).Inc()
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) abandon(ctx context.Context, event Event, cause error) error {
+	if err := d.Store.Abandoned(ctx, event.Seq); err != nil {
+		return err
+	}
+
+	if d.Metrics != nil {
+		d.Metrics.Abandoned.Inc()
+	}
+
+	log.
+		Error().
+		Err(cause).
+		Fields(map[string]any{
+			"event_id":   event.ID,
+			"event_type": event.Type,
+			"attempts":   event.Attempts + 1,
+		}).
+		Msg("Gave up delivering event; marked it failed.")
+
+	return nil
+}
+
+// Failing to prune only leaves events for longer, so it's logged and tried
+// again later.
+func (d *Dispatcher) prune(ctx context.Context) {
+	pruned, err := d.Store.Prune(ctx, time.Now().Add(-d.Retention))
+	if err != nil {
+		if ctx.Err() == nil {
+			log.
+				Warn().
+				Err(err).
+				Msg("Could not prune delivered events.")
+		}
+
+		return
+	}
+
+	if pruned > 0 {
+		log.
+			Debug().
+			Fields(map[string]any{
+				"pruned": pruned,
+			}).
+			Msg("Pruned delivered events.")
+	}
+}
+
+// Doubles the wait after each failure in a row, up to MaxBackoff.
+func (d *Dispatcher) backoff(failures int) time.Duration {
+	wait := d.Interval
+	for i := 0; i < failures && wait < d.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > d.MaxBackoff {
+		return d.MaxBackoff
+	}
+
+	return wait
+}
@@ -0,0 +1,108 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memoryStore keeps events in the order they were written.
+type memoryStore struct {
+	events    []Event
+	delivered map[int64]bool
+	failed    map[int64]bool
+}
+
+func (s *memoryStore) Pending(_ context.Context, limit int) ([]Event, error) {
+	var pending []Event
+	for _, event := range s.events {
+		if !s.delivered[event.Seq] && !s.failed[event.Seq] && len(pending) < limit {
+			pending = append(pending, event)
+		}
+	}
+
+	return pending, nil
+}
+
+func (s *memoryStore) Delivered(_ context.Context, seq int64) error {
+	s.delivered[seq] = true
+	return nil
+}
+
+func (s *memoryStore) Failed(_ context.Context, seq int64) error {
+	for i := range s.events {
+		if s.events[i].Seq == seq {
+			s.events[i].Attempts++
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Abandoned(ctx context.Context, seq int64) error {
+	s.failed[seq] = true
+	return s.Failed(ctx, seq)
+}
+
+func (s *memoryStore) Prune(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+// rejectingSink rejects the events whose IDs it's given, and records the
+// rest in the order it receives them.
+type rejectingSink struct {
+	reject   map[string]bool
+	received []string
+}
+
+func (s *rejectingSink) Name() string { return "rejecting" }
+
+func (s *rejectingSink) Deliver(_ context.Context, event Event) error {
+	if s.reject[event.ID] {
+		return errors.New("rejected")
+	}
+
+	s.received = append(s.received, event.ID)
+	return nil
+}
+
+func TestDispatchAbandonsAfterMaxAttempts(t *testing.T) {
+	const MaxAttempts = 3
+
+	store := &memoryStore{
+		events:    []Event{{Seq: 1, ID: "poison"}, {Seq: 2, ID: "next"}},
+		delivered: make(map[int64]bool),
+		failed:    make(map[int64]bool),
+	}
+	sink := &rejectingSink{reject: map[string]bool{"poison": true}}
+	dispatcher := Dispatcher{
+		Store:       store,
+		Sinks:       []Sink{sink},
+		BatchSize:   10,
+		MaxAttempts: MaxAttempts,
+	}
+
+	// Every attempt but the last fails, holding back the next event.
+	for attempt := 1; attempt < MaxAttempts; attempt++ {
+		if err := dispatcher.dispatch(context.Background()); err == nil {
+			t.Fatalf("attempt %d: expected the event to fail", attempt)
+		}
+
+		if len(sink.received) > 0 {
+			t.Fatalf("attempt %d: delivered %v ahead of the failing event", attempt, sink.received)
+		}
+	}
+
+	if err := dispatcher.dispatch(context.Background()); err != nil {
+		t.Fatalf("expected the failing event to be abandoned, got %v", err)
+	}
+
+	if !store.failed[1] || store.events[0].Attempts != MaxAttempts {
+		t.Fatalf("expected the event to be marked failed after %d attempts, got %d", MaxAttempts, store.events[0].Attempts)
+	}
+
+	if len(sink.received) != 1 || sink.received[0] != "next" || !store.delivered[2] {
+		t.Fatalf("expected the next event to be delivered, got %v", sink.received)
+	}
+}
@@ -0,0 +1,154 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/standoffvenus/future/internal/tracing"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/standoffvenus/future/internal/outbox")
+
+// Event is a change other systems may react to. Events are delivered at
+// least once, so consumers should ignore an ID they've already seen.
+type Event struct {
+	ID            string              `json:"id"`
+	Type          string              `json:"type"`
+	TenantID      string              `json:"tenant_id"`
+	AppointmentID string              `json:"appointment_id"`
+	OccurredAt    time.Time           `json:"occurred_at"`
+	Data          jsoniter.RawMessage `json:"data"`
+
+	// Seq orders events as they were written, and Attempts counts the
+	// deliveries of the event that have failed.
+	Seq      int64 `json:"-"`
+	Attempts int   `json:"-"`
+}
+
+// SQLStore reads the events the appointment repository writes, in the same
+// transaction as each change, to a table created by the appointment schema's
+// migrations.
+type SQLStore struct {
+	Database *sql.DB
+	Table    string
+}
+
+// Pending returns up to limit events that are neither delivered nor failed,
+// oldest first.
+func (s *SQLStore) Pending(ctx context.Context, limit int) (events []Event, err error) {
+	ctx, span := tracer.Start(ctx, "SQLStore.Pending")
+	defer func() { tracing.End(span, err) }()
+
+	const Query = `
+SELECT seq, id, tenant_id, type, appointment_id, occurred_at, data, attempts
+  FROM %s
+ WHERE delivered_at IS NULL
+   AND failed_at IS NULL
+ ORDER BY seq
+ LIMIT :limit
+`
+
+	rows, err := s.Database.QueryContext(ctx, fmt.Sprintf(Query, s.Table), sql.Named("limit", limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			event      Event
+			occurredAt int64
+			data       string
+		)
+		err := rows.Scan(&event.Seq, &event.ID, &event.TenantID, &event.Type, &event.AppointmentID, &occurredAt, &data, &event.Attempts)
+		if err != nil {
+			return nil, err
+		}
+
+		event.OccurredAt, event.Data = time.Unix(occurredAt, 0).UTC(), jsoniter.RawMessage(data)
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// Delivered marks an event as delivered to every sink, so it isn't sent
+// again. Delivered events are kept, as a record of what was sent, until
+// they're pruned.
+func (s *SQLStore) Delivered(ctx context.Context, seq int64) (err error) {
+	ctx, span := tracer.Start(ctx, "SQLStore.Delivered")
+	defer func() { tracing.End(span, err) }()
+
+	const Update = `
+UPDATE %s
+   SET delivered_at = :now
+ WHERE seq = :seq
+`
+
+	_, err = s.Database.ExecContext(ctx, fmt.Sprintf(Update, s.Table),
+		sql.Named("now", time.Now().Unix()),
+		sql.Named("seq", seq))
+
+	return err
+}
+
+// Failed counts a failed attempt to deliver an event.
+func (s *SQLStore) Failed(ctx context.Context, seq int64) (err error) {
+	ctx, span := tracer.Start(ctx, "SQLStore.Failed")
+	defer func() { tracing.End(span, err) }()
+
+	const Update = `
+UPDATE %s
+   SET attempts = attempts + 1
+ WHERE seq = :seq
+`
+
+	_, err = s.Database.ExecContext(ctx, fmt.Sprintf(Update, s.Table), sql.Named("seq", seq))
+
+	return err
+}
+
+// Abandoned counts a failed attempt to deliver an event, and marks it failed
+// so it's no longer retried. Failed events are kept, and can be retried by
+// clearing their failed_at.
+func (s *SQLStore) Abandoned(ctx context.Context, seq int64) (err error) {
+	ctx, span := tracer.Start(ctx, "SQLStore.Abandoned")
+	defer func() { tracing.End(span, err) }()
+
+	const Update = `
+UPDATE %s
+   SET attempts = attempts + 1,
+       failed_at = :now
+ WHERE seq = :seq
+`
+
+	_, err = s.Database.ExecContext(ctx, fmt.Sprintf(Update, s.Table),
+		sql.Named("now", time.Now().Unix()),
+		sql.Named("seq", seq))
+
+	return err
+}
+
+// Prune removes the events delivered before the given time, returning how
+// many it removed.
+func (s *SQLStore) Prune(ctx context.Context, before time.Time) (pruned int64, err error) {
+	ctx, span := tracer.Start(ctx, "SQLStore.Prune")
+	defer func() { tracing.End(span, err) }()
+
+	const Delete = `
+DELETE FROM %s
+ WHERE delivered_at IS NOT NULL
+   AND delivered_at < :before
+`
+
+	result, err := s.Database.ExecContext(ctx, fmt.Sprintf(Delete, s.Table), sql.Named("before", before.Unix()))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
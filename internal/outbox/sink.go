@@ -0,0 +1,117 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/rs/zerolog/log"
+)
+
+// Sink is somewhere events are delivered. Deliver must only return nil once
+// the event is safely delivered; otherwise it's retried.
+type Sink interface {
+	Name() string
+	Deliver(context.Context, Event) error
+}
+
+// LogSink writes each event to the server's log.
+type LogSink struct{}
+
+func (LogSink) Name() string { return "log" }
+
+func (LogSink) Deliver(_ context.Context, event Event) error {
+	log.
+		Info().
+		Fields(map[string]any{
+			"event_id":       event.ID,
+			"event_type":     event.Type,
+			"tenant":         event.TenantID,
+			"appointment_id": event.AppointmentID,
+			"occurred_at":    event.OccurredAt,
+		}).
+		Msg("Appointment event.")
+
+	return nil
+}
+
+// FileSink appends each event to File as a line of JSON.
+type FileSink struct {
+	File string
+
+	mu sync.Mutex
+}
+
+func (s *FileSink) Name() string { return "file" }
+
+func (s *FileSink) Deliver(_ context.Context, event Event) error {
+	line, err := jsoniter.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	// Synced, so an event marked delivered survives a crash.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// WebhookSink POSTs each event to URL as JSON. Any 2xx response delivers
+// it; anything else is retried.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Deliver(ctx context.Context, event Event) error {
+	body, err := jsoniter.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", event.ID)
+	req.Header.Set("X-Event-Type", event.Type)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drained, so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package tenant
+
+import "context"
+
+// Default is the tenant of a single gym's deployment, and of appointments
+// booked before there were tenants.
+const Default = "default"
+
+type key struct{}
+
+// WithID returns a context whose work is done for the tenant.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// FromContext returns the tenant the context's work is done for, or Default.
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(key{}).(string); ok {
+		return id
+	}
+
+	return Default
+}
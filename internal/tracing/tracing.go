@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+type Config struct {
+	ServiceName    string
+	ServiceVersion string
+
+	// Exporter is one of ExporterNone, ExporterStdout or ExporterOTLP.
+	Exporter string
+
+	// OTLPEndpoint is the host and port of an OTLP/HTTP collector. If empty,
+	// the exporter falls back to the OTEL_EXPORTER_OTLP_ENDPOINT environment
+	// variable, and then to localhost:4318.
+	OTLPEndpoint string
+	OTLPInsecure bool
+
+	// SampleRatio is the fraction of new traces recorded; traces continued
+	// from a consumer's traceparent follow the consumer's decision.
+	SampleRatio float64
+
+	// Stdout is where ExporterStdout writes spans.
+	Stdout io.Writer
+}
+
+// Setup installs the global tracer provider and W3C trace context
+// propagator. The returned func flushes any buffered spans and stops
+// exporting; spans are discarded until Setup is called.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{}))
+
+	exporter, err := newExporter(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(config.ServiceName),
+		semconv.ServiceVersionKey.String(config.ServiceVersion)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, config Config) (sdktrace.SpanExporter, error) {
+	switch config.Exporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(config.Stdout))
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if config.OTLPEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(config.OTLPEndpoint))
+		}
+
+		if config.OTLPInsecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+
+		return otlptracehttp.New(ctx, options...)
+	}
+
+	return nil, fmt.Errorf("unknown trace exporter %q (expected %q, %q or %q)",
+		config.Exporter, ExporterNone, ExporterStdout, ExporterOTLP)
+}
+
+// StartQuery starts a client span for one SQL statement against a table.
+func StartQuery(ctx context.Context, tracer trace.Tracer, table, operation, statement string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, operation+" "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemSqlite,
+			semconv.DBSQLTableKey.String(table),
+			semconv.DBOperationKey.String(operation),
+			semconv.DBStatementKey.String(statement)))
+}
+
+// Query runs fn, which executes statement against table, in a span started
+// by StartQuery. sql.ErrNoRows is an answer rather than a failure, so it
+// isn't recorded on the span.
+func Query(ctx context.Context, tracer trace.Tracer, table, operation, statement string, fn func(context.Context) error) error {
+	ctx, span := StartQuery(ctx, tracer, table, operation, statement)
+	err := fn(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		End(span, nil)
+	} else {
+		End(span, err)
+	}
+
+	return err
+}
+
+// End records err, if any, on the span before ending it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}